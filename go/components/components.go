@@ -0,0 +1,142 @@
+// Connected blobs of solid pixels, what smart-crop crops and process' structure operation counts
+package components
+
+import "image"
+
+// The usual alpha threshold, >= counts as solid. Wplace alpha is only ever 0 or 255 anyway
+const AlphaThreshold = 16
+
+// A blob's bounding box (inclusive) and how many solid pixels it has
+type Component struct {
+	MinX, MinY int
+	MaxX, MaxY int
+	Count      int
+}
+
+// One byte per pixel, 1 for solid (alpha >= threshold), row after row
+func MaskNRGBA(img *image.NRGBA, threshold int) (w, h int, mask []byte, solidCount int) {
+	b := img.Bounds()
+	w, h = b.Dx(), b.Dy()
+	mask = make([]byte, w*h)
+
+	p := 0
+	for y := range h {
+		row := y * img.Stride
+		for x := range w {
+			if int(img.Pix[row+4*x+3]) >= threshold {
+				mask[p] = 1
+				solidCount++
+			}
+			p++
+		}
+	}
+	return
+}
+
+// Grows the mask by r pixels in every direction, diagonals included (a 2r+1 square). Done as a
+// row pass then a column pass, each keeping a running count of solid pixels in the window, so it
+// costs the same whatever r is
+func Dilate(w, h int, mask []byte, r int) []byte {
+	out := make([]byte, len(mask))
+	if r <= 0 {
+		copy(out, mask)
+		return out
+	}
+
+	rows := make([]byte, len(mask))
+	for y := range h {
+		dilateLine(mask[y*w:], rows[y*w:], w, 1, r)
+	}
+	for x := range w {
+		dilateLine(rows[x:], out[x:], h, w, r)
+	}
+	return out
+}
+
+// One line of n pixels, step apart
+func dilateLine(src, dst []byte, n, step, r int) {
+	solid := 0
+	// Window for pixel 0 is [-r, r], the part off the edge counts as empty
+	for i := 0; i < r && i < n; i++ {
+		solid += int(src[i*step])
+	}
+	for i := range n {
+		if in := i + r; in < n {
+			solid += int(src[in*step])
+		}
+		if out := i - r - 1; out >= 0 {
+			solid -= int(src[out*step])
+		}
+		if solid > 0 {
+			dst[i*step] = 1
+		}
+	}
+}
+
+// 8-connected blobs, in the order their first pixel comes up going row by row
+func Find8(w, h int, mask []byte) []Component {
+	visited := make([]byte, len(mask))
+	idx := func(x, y int) int { return y*w + x }
+
+	// One int32 per pixel instead of an x and a y, process runs this on every tile
+	queue := make([]int32, w*h)
+
+	var comps []Component
+
+	for y := range h {
+		for x := range w {
+			p := idx(x, y)
+			if mask[p] == 0 || visited[p] != 0 {
+				continue
+			}
+			head, tail := 0, 0
+			queue[tail] = int32(p)
+			tail++
+			visited[p] = 1
+
+			minX, maxX := x, x
+			minY, maxY := y, y
+			count := 0
+
+			for head < tail {
+				cx, cy := int(queue[head])%w, int(queue[head])/w
+				head++
+				count++
+
+				if cx < minX {
+					minX = cx
+				}
+				if cx > maxX {
+					maxX = cx
+				}
+				if cy < minY {
+					minY = cy
+				}
+				if cy > maxY {
+					maxY = cy
+				}
+
+				for dy := -1; dy <= 1; dy++ {
+					for dx := -1; dx <= 1; dx++ {
+						if dx == 0 && dy == 0 {
+							continue
+						}
+						nx, ny := cx+dx, cy+dy
+						if nx < 0 || ny < 0 || nx >= w || ny >= h {
+							continue
+						}
+						np := idx(nx, ny)
+						if mask[np] == 0 || visited[np] != 0 {
+							continue
+						}
+						visited[np] = 1
+						queue[tail] = int32(np)
+						tail++
+					}
+				}
+			}
+			comps = append(comps, Component{minX, minY, maxX, maxY, count})
+		}
+	}
+	return comps
+}
@@ -0,0 +1,92 @@
+package components
+
+import (
+	"image"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+// The square kernel smart-crop and process used to run, checking every pixel in the window
+func dilateBruteForce(w, h int, mask []byte, r int) []byte {
+	out := make([]byte, len(mask))
+	for y := range h {
+		for x := range w {
+			for dy := -r; dy <= r; dy++ {
+				for dx := -r; dx <= r; dx++ {
+					xx, yy := x+dx, y+dy
+					if xx >= 0 && xx < w && yy >= 0 && yy < h && mask[yy*w+xx] != 0 {
+						out[y*w+x] = 1
+					}
+				}
+			}
+		}
+	}
+	return out
+}
+
+func TestDilateMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	sizes := [][2]int{{1, 1}, {1, 9}, {9, 1}, {5, 5}, {17, 11}, {40, 33}}
+	for _, size := range sizes {
+		w, h := size[0], size[1]
+		for _, density := range []float64{0, 0.01, 0.1, 0.5} {
+			mask := make([]byte, w*h)
+			for i := range mask {
+				if rng.Float64() < density {
+					mask[i] = 1
+				}
+			}
+			for r := range 20 {
+				want := dilateBruteForce(w, h, mask, r)
+				if got := Dilate(w, h, mask, r); !slices.Equal(got, want) {
+					t.Errorf("%dx%d density %.2f r=%d: separable dilation differs from the square kernel", w, h, density, r)
+				}
+			}
+		}
+	}
+}
+
+func TestDilateCopies(t *testing.T) {
+	mask := []byte{0, 1, 0, 0}
+	got := Dilate(2, 2, mask, 0)
+	got[0] = 1
+	if mask[0] != 0 {
+		t.Error("r=0 handed back the mask itself")
+	}
+}
+
+func TestFind8(t *testing.T) {
+	// Two blobs, the diagonal only joins up with 8-connectivity
+	//   x . . . .
+	//   . x . . x
+	//   . . x . x
+	w, h := 5, 3
+	mask := []byte{
+		1, 0, 0, 0, 0,
+		0, 1, 0, 0, 1,
+		0, 0, 1, 0, 1,
+	}
+	got := Find8(w, h, mask)
+	want := []Component{
+		{MinX: 0, MinY: 0, MaxX: 2, MaxY: 2, Count: 3},
+		{MinX: 4, MinY: 1, MaxX: 4, MaxY: 2, Count: 2},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Find8 = %+v, want %+v", got, want)
+	}
+}
+
+func TestMaskNRGBA(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	for x, a := range []uint8{0, AlphaThreshold - 1, AlphaThreshold} {
+		img.Pix[x*4+3] = a
+	}
+	w, h, mask, solid := MaskNRGBA(img, AlphaThreshold)
+	if w != 3 || h != 1 || solid != 1 || !slices.Equal(mask, []byte{0, 0, 1}) {
+		t.Errorf("MaskNRGBA = %d, %d, %v, %d", w, h, mask, solid)
+	}
+	if _, _, mask, _ := MaskNRGBA(img, 1); !slices.Equal(mask, []byte{0, 1, 1}) {
+		t.Errorf("threshold 1: mask = %v", mask)
+	}
+}
@@ -0,0 +1,3 @@
+module components
+
+go 1.25.1
@@ -4,12 +4,12 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"sync"
-	"time"
+
+	"tilearchive"
 )
 
 type Target struct {
@@ -32,7 +32,7 @@ var (
 var targets []Target
 
 func init() {
-	flag.StringVar(&sevenZipPath, "7zip", "C:\\Program Files\\7-Zip\\7z.exe", "Path to 7zip executable")
+	flag.StringVar(&sevenZipPath, "7zip", "", "Deprecated, does nothing. Archives are read directly now, no 7z needed")
 	flag.StringVar(&basePath, "base", "C:\\Users\\jazza\\Downloads\\wplace", "Path to folder contain tiles-x.7z files")
 	flag.IntVar(&startIndex, "start", 1, "Archive start index")
 	flag.IntVar(&endIndex, "end", -1, "Archive end index. If -1, will be set to parse all archives.")
@@ -40,10 +40,15 @@ func init() {
 	flag.IntVar(&rightX, "right", 1860, "Right tile X")
 	flag.IntVar(&topY, "top", 1281, "Top tile Y")
 	flag.IntVar(&bottomY, "bottom", 1282, "Bottom tile Y")
-	flag.IntVar(&workers, "workers", 24, "Number of instances of 7z to run in parallel")
+	flag.IntVar(&workers, "workers", 24, "Number of archives to read in parallel")
 
 	flag.Parse()
 
+	// Still accepted so old scripts keep working
+	if sevenZipPath != "" {
+		fmt.Fprintf(os.Stderr, "Warning: -7zip is deprecated and ignored, archives are read without 7z now\n")
+	}
+
 	if endIndex == -1 {
 		endIndex = findEndIndex(basePath)
 	}
@@ -120,45 +125,31 @@ func worker(i int) {
 }
 
 func extractMultipleFromArchive(archiveIndex int, targets []Target) error {
-	archive := filepath.Join(basePath, fmt.Sprintf("tiles-%d.7z", archiveIndex))
-
-	internal := make([]string, 0, len(targets))
-	for _, t := range targets {
-		internal = append(internal, fmt.Sprintf("tiles-%d/%s/%s.png", archiveIndex, t.X, t.Y))
-	}
-
-	tempOut := filepath.Join(basePath, fmt.Sprintf("__tmp_extract_%d_%d", archiveIndex, time.Now().UnixNano()))
-	if err := os.MkdirAll(tempOut, 0o755); err != nil {
-		return fmt.Errorf("mkdir temp: %w", err)
-	}
-
-	args := []string{"x", archive}
-	args = append(args, internal...)
-	args = append(args, "-o"+tempOut, "-y")
-
-	cmd := exec.Command(sevenZipPath, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	if err := cmd.Run(); err != nil {
-		_ = os.RemoveAll(tempOut)
-		return fmt.Errorf("7z run: %w", err)
+	archive, err := tilearchive.Open(tilearchive.ArchivePath(basePath, archiveIndex))
+	if err != nil {
+		return fmt.Errorf("open archive: %w", err)
 	}
+	defer archive.Close()
 
+	coords := make([]tilearchive.Coord, 0, len(targets))
 	for _, t := range targets {
-		from := filepath.Join(tempOut, fmt.Sprintf("tiles-%d", archiveIndex), t.X, t.Y+".png")
-		to := filepath.Join(basePath, fmt.Sprintf("%d-X%s-Y%s.png", archiveIndex, t.X, t.Y))
-
-		_ = os.Remove(to)
-
-		if err := os.Rename(from, to); err != nil {
-			_ = os.RemoveAll(tempOut)
-			return fmt.Errorf("rename %s -> %s: %w", from, to, err)
+		x, errX := strconv.Atoi(t.X)
+		y, errY := strconv.Atoi(t.Y)
+		if errX != nil || errY != nil {
+			return fmt.Errorf("bad target %s/%s", t.X, t.Y)
+		}
+		if !archive.Has(x, y) {
+			return fmt.Errorf("tiles-%d/%s/%s.png is not in the archive", archiveIndex, t.X, t.Y)
 		}
+		coords = append(coords, tilearchive.Coord{X: x, Y: y})
 	}
 
-	if err := os.RemoveAll(tempOut); err != nil {
-		return fmt.Errorf("cleanup temp: %w", err)
-	}
-	return nil
+	// Straight from the archive into the output file, no temp folder
+	return archive.WalkTiles(coords, 1, func(e tilearchive.Entry, data []byte) error {
+		to := filepath.Join(basePath, fmt.Sprintf("%d-X%d-Y%d.png", archiveIndex, e.X, e.Y))
+		if err := os.WriteFile(to, data, 0o644); err != nil {
+			return fmt.Errorf("write %s: %w", to, err)
+		}
+		return nil
+	})
 }
@@ -0,0 +1,107 @@
+package main
+
+import "fmt"
+
+func init() {
+	registerOperation(OperationSpec{
+		Key:   "a",
+		Flags: "t",
+		Usage: "average (t=include transparent)",
+		New: func(o Options) (Operation, error) {
+			return averageOperation{name: "average" + o.Suffix(), includeTransparency: o.Has('t')}, nil
+		},
+	})
+}
+
+type averageOperation struct {
+	pureOperation
+	name                string
+	includeTransparency bool
+}
+
+func (op averageOperation) Name() string {
+	return op.name
+}
+
+func (averageOperation) RecordSize() int {
+	return 3
+}
+
+func (op averageOperation) Process(tile *Tile, rec []byte) error {
+	if tile.paletted() {
+		averagePaletted(tile.Index, tile.Palette, op.includeTransparency).put(rec)
+		return nil
+	}
+
+	rgb, err := averageRGBA(tile.Pix, tile.Width, tile.Height, op.includeTransparency)
+	if err != nil {
+		return err
+	}
+	rgb.put(rec)
+	return nil
+}
+
+func (op averageOperation) Encode(out *Output, grid *Grid) error {
+	return out.SaveRGB(op.name, grid.Width, grid.Height, func(x, y int) RGB {
+		return rgbFromRecord(grid.At(x, y))
+	})
+}
+
+// Transparent entries are premultiplied to black, same as transparent pixels in the RGBA path
+func averagePaletted(index []uint8, palette *tilePalette, includeTransparency bool) RGB {
+	var r, g, b, count uint64
+	for i, n := range indexCounts(index) {
+		e := palette[i]
+		if n == 0 || (!e.painted && !includeTransparency) {
+			continue
+		}
+
+		rgb := unpackRGB(e.packed)
+		r += uint64(rgb.R) * uint64(n)
+		g += uint64(rgb.G) * uint64(n)
+		b += uint64(rgb.B) * uint64(n)
+		count += uint64(n)
+	}
+
+	if count == 0 {
+		return RGB{0, 0, 0}
+	}
+
+	return RGB{
+		R: uint8(r / count),
+		G: uint8(g / count),
+		B: uint8(b / count),
+	}
+}
+
+func averageRGBA(pixels []uint8, width, height int, includeTransparency bool) (RGB, error) {
+	if width <= 0 || height <= 0 || len(pixels) < width*height*4 {
+		return RGB{}, fmt.Errorf("invalid input")
+	}
+
+	var r, g, b, count uint64
+	for i := 0; i < len(pixels); i += 4 {
+		// Since my images never contain semi-transparent pixels (alpha is always 0 or 255)
+		// I decided for simplicity and speed (up to 13% from small test?), I can just not handle partial transparency
+		// This makes the function less general; images with semi-transparent pixels will not work as expected
+		alpha := pixels[i+3]
+		if alpha == 0 && !includeTransparency {
+			continue
+		}
+
+		r += uint64(pixels[i])
+		g += uint64(pixels[i+1])
+		b += uint64(pixels[i+2])
+		count++
+	}
+
+	if count == 0 {
+		return RGB{0, 0, 0}, nil
+	}
+
+	return RGB{
+		R: uint8(r / count),
+		G: uint8(g / count),
+		B: uint8(b / count),
+	}, nil
+}
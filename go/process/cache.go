@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// Most tiles don't change between two pulls, so with -i every folder leaves a hash of every tile's
+// file and every operation's records behind in the data folder:
+//
+//	<folder>-hashes.cache  "WPH1" | width uint32 | height uint32 | 8 | uint64 per tile, x-major, 0 = no hash
+//	<folder>-<op>.cache    "WPH1" | width uint32 | height uint32 | record size uint32 | records, x-major
+//
+// The next folder still reads every file to hash it, but only decodes the ones whose hash is different,
+// everything else gets its records straight out of the folder before's cache
+const cacheMagic = "WPH1"
+
+// Set with -i
+var incremental bool
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// Operations that can fill in a record without the pixels for a tile that's byte for byte the same as
+// in the folder before. prev is the folder before's record for it. Returning false means it can't this
+// time, and the tile gets decoded as usual
+type UnchangedTileProcessor interface {
+	ProcessUnchanged(tile *Tile, prev, rec []byte) bool
+}
+
+// Embedded by operations whose record only depends on the tile's own pixels, the record from last time
+// is already the answer
+type pureOperation struct{}
+
+func (pureOperation) ProcessUnchanged(tile *Tile, prev, rec []byte) bool {
+	copy(rec, prev)
+	return true
+}
+
+type tileCache struct {
+	hashes *Grid
+
+	// nil if there's nothing to reuse, only ever all or nothing
+	prevHashes  *Grid
+	prevRecords []*Grid
+
+	reused atomic.Int64
+}
+
+func hashTile(data []byte) uint64 {
+	// 0 means no hash, so one unlucky tile just gets decoded every time
+	return max(crc64.Checksum(data, crcTable), 1)
+}
+
+func cachePath(dir string, folder int, name string) string {
+	return fmt.Sprintf("%s/%d-%s.cache", dir, folder, name)
+}
+
+// Loads whatever the folder before left, anything missing or for something else means starting fresh
+func newTileCache(out *Output, ops []Operation, region Region) *tileCache {
+	c := &tileCache{hashes: newGrid(region, 8)}
+	prev := out.Folder - 1
+
+	for _, op := range ops {
+		if _, ok := op.(UnchangedTileProcessor); !ok {
+			fmt.Printf("Not reusing anything from folder %d, %s always needs the pixels\n", prev, op.Name())
+			return c
+		}
+	}
+
+	hashes, err := loadCacheGrid(cachePath(out.Dir, prev, "hashes"), region, 8)
+	if err != nil {
+		printCacheMiss(prev, "hashes", err)
+		return c
+	}
+
+	records := make([]*Grid, len(ops))
+	for i, op := range ops {
+		records[i], err = loadCacheGrid(cachePath(out.Dir, prev, op.Name()), region, op.RecordSize())
+		if err != nil {
+			printCacheMiss(prev, op.Name(), err)
+			return c
+		}
+	}
+
+	fmt.Printf("Reusing results from folder %d for unchanged tiles\n", prev)
+	c.prevHashes, c.prevRecords = hashes, records
+	return c
+}
+
+func printCacheMiss(folder int, name string, err error) {
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("No %s cache from folder %d, every tile gets decoded\n", name, folder)
+	} else {
+		fmt.Printf("Not reusing the cache from folder %d: %v\n", folder, err)
+	}
+}
+
+// Called with the tile's hash before it's decoded. true means every operation's record is filled in
+// and there's nothing left to do
+func (c *tileCache) reuse(tile *Tile, ops []Operation, grids []*Grid) bool {
+	binary.LittleEndian.PutUint64(c.hashes.AtTile(tile.X, tile.Y), tile.Hash)
+
+	if c.prevHashes == nil || binary.LittleEndian.Uint64(c.prevHashes.AtTile(tile.X, tile.Y)) != tile.Hash {
+		return false
+	}
+
+	for i, op := range ops {
+		if !op.(UnchangedTileProcessor).ProcessUnchanged(tile, c.prevRecords[i].AtTile(tile.X, tile.Y), grids[i].AtTile(tile.X, tile.Y)) {
+			return false
+		}
+	}
+	c.reused.Add(1)
+	return true
+}
+
+// A bad tile never gets reused, it might be fixed next time
+func (c *tileCache) forget(x, y int) {
+	clear(c.hashes.AtTile(x, y))
+}
+
+func (c *tileCache) save(out *Output, ops []Operation, grids []*Grid) error {
+	if err := saveCacheGrid(cachePath(out.Dir, out.Folder, "hashes"), c.hashes); err != nil {
+		return err
+	}
+	for i, op := range ops {
+		if err := saveCacheGrid(cachePath(out.Dir, out.Folder, op.Name()), grids[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadCacheGrid(path string, region Region, recordSize int) (*Grid, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReaderSize(file, 1<<20)
+
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	width := int(binary.LittleEndian.Uint32(header[4:8]))
+	height := int(binary.LittleEndian.Uint32(header[8:12]))
+	size := int(binary.LittleEndian.Uint32(header[12:16]))
+	if string(header[0:4]) != cacheMagic || width != region.Width || height != region.Height || size != recordSize {
+		return nil, fmt.Errorf("%s is for something else", path)
+	}
+
+	grid := newGrid(region, recordSize)
+	if _, err := io.ReadFull(r, grid.Data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return grid, nil
+}
+
+func saveCacheGrid(path string, grid *Grid) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriterSize(file, 1<<20)
+
+	header := make([]byte, 0, 16)
+	header = append(header, cacheMagic...)
+	for _, v := range []int{grid.Width, grid.Height, grid.RecordSize} {
+		header = binary.LittleEndian.AppendUint32(header, uint32(v))
+	}
+	w.Write(header)
+	w.Write(grid.Data)
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
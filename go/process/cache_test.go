@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Covers reusing (c, m, s, g), an op that looks at the folder before (d) and one with its own state (t)
+const cacheTestOperations = "c m s:f=bin d g t"
+
+var cacheTestRegion = Region{X: 10, Y: 10, Width: 3, Height: 2}
+
+// Folder 2 has one tile the same, one repainted, one wiped, one new and one broken. Folder 0 is there
+// but empty, for diff to compare folder 1 against
+func writeCacheTestFolders(t *testing.T, dir string) (string, string) {
+	writeTestFolder(t, dir, 0, nil)
+	same, repainted := testWorldTile(1), testWorldTile(2)
+	first := writeTestFolder(t, dir, 1, map[[2]int]image.Image{
+		{10, 10}: same,
+		{11, 10}: repainted,
+		{12, 11}: testWorldTile(3),
+	})
+
+	changed := image.NewPaletted(repainted.Rect, repainted.Palette)
+	copy(changed.Pix, repainted.Pix)
+	for i := range 5000 {
+		changed.Pix[i*7] = 1
+	}
+	second := writeTestFolder(t, dir, 2, map[[2]int]image.Image{
+		{10, 10}: same,
+		{11, 10}: changed,
+		{10, 11}: testWorldTile(4),
+		{11, 11}: nil,
+	})
+	return first, second
+}
+
+func runCacheTestFolders(t *testing.T, incr bool, between func(out string)) map[string][]byte {
+	dir := useTestWorld(t, incr)
+	first, second := writeCacheTestFolders(t, dir)
+
+	ops, err := parseOperations(cacheTestOperations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := outputFolderFor(cacheTestRegion)
+
+	runProcess(1, ops, cacheTestRegion, 2, TileSource{Path: first})
+	if between != nil {
+		between(out)
+	}
+	runProcess(2, ops, cacheTestRegion, 2, TileSource{Path: second})
+
+	files := readOutputs(t, out)
+	for name := range files {
+		if !strings.HasPrefix(name, "2-") {
+			delete(files, name)
+		}
+	}
+	return files
+}
+
+// Everything -i writes has to come out the same as working every tile out again
+func TestCacheMatchesFullRun(t *testing.T) {
+	full := runCacheTestFolders(t, false, nil)
+	cached := runCacheTestFolders(t, true, nil)
+
+	if len(full) == 0 {
+		t.Fatal("no outputs")
+	}
+	for name, want := range full {
+		got, ok := cached[name]
+		if !ok {
+			t.Errorf("%s is missing with -i", name)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s differs with -i", name)
+		}
+	}
+
+	var caches []string
+	for name := range cached {
+		if _, ok := full[name]; !ok {
+			caches = append(caches, name)
+		}
+	}
+	slices.Sort(caches)
+	want := []string{"2-count.cache", "2-diff.cache", "2-hashes.cache", "2-mode.cache", "2-series.cache", "2-staleness.cache", "2-stats.cache"}
+	if !slices.Equal(caches, want) {
+		t.Errorf("-i also wrote %v, want %v", caches, want)
+	}
+}
+
+// Poisons folder 1's cached count for the one unchanged tile, if -i really reused it folder 2 has
+// the same poisoned count. The repainted tile has to be worked out again
+func TestCacheReusesUnchangedTiles(t *testing.T) {
+	var poisoned []byte
+	cached := runCacheTestFolders(t, true, func(out string) {
+		path := cachePath(out, 1, "count")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		binary.LittleEndian.PutUint32(data[16:], 123456789)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		poisoned = data
+	})
+
+	counts := cached["2-count.cache"]
+	if got := binary.LittleEndian.Uint32(counts[16:]); got != 123456789 {
+		t.Errorf("unchanged tile's count = %d, wasn't reused", got)
+	}
+	repainted := 16 + cacheTestRegion.Height*4
+	if bytes.Equal(counts[repainted:repainted+4], poisoned[repainted:repainted+4]) {
+		t.Errorf("repainted tile kept folder 1's count")
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strings"
+)
+
+// A checkpoint is an append-only sidecar next to the outputs, <data>/<folder>-<key>.checkpoint.
+// It starts with a header saying which operations it belongs to:
+//
+//	"WPC2" | key length uint32 | key
+//
+// then every finished column of tiles gets appended as one chunk:
+//
+//	x uint32 | every operation's records for that column, in operation order, then the sidecars |
+//	bad tiles uint32 | per bad tile: y uint32 | detail length uint16 | detail | crc32 of the above
+//
+// The details are the error messages for errors.csv, everything else about a bad tile is in the
+// status sidecar. Columns are only written once every tile in them is done, and workers never touch
+// a finished column again, so nothing has to be paused to save one. A chunk cut off by a crash fails
+// its crc and gets dropped, that column is simply done again.
+const checkpointMagic = "WPC2"
+
+// Longer error messages get cut off in the checkpoint, they're only for reading anyway
+const maxCheckpointDetail = math.MaxUint16
+
+type checkpoint struct {
+	path   string
+	header []byte
+
+	grids     []*Grid
+	report    *tileReport
+	remaining []int
+	done      []bool
+	pending   []int
+
+	file *os.File
+	w    *bufio.Writer
+}
+
+// sidecars (the tile status, and the hashes with -i) are saved alongside the operations' grids, so a
+// resume still knows about bad tiles and unchanged ones. report's details go in as well
+func newCheckpoint(dir string, folderNumber int, ops []Operation, grids []*Grid, sidecars []*Grid, report *tileReport) *checkpoint {
+	width, height := grids[0].Width, grids[0].Height
+
+	// Everything that changes the records has to be in the key, otherwise a resume would mix results
+	parts := []string{fmt.Sprintf("%dx%d", width, height)}
+	for _, op := range ops {
+		parts = append(parts, fmt.Sprintf("%s/%d", op.Name(), op.RecordSize()))
+	}
+	for _, g := range sidecars {
+		parts = append(parts, fmt.Sprintf("sidecar/%d", g.RecordSize))
+	}
+	key := strings.Join(parts, ",")
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	header := []byte(checkpointMagic)
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(key)))
+	header = append(header, key...)
+
+	c := &checkpoint{
+		path:      fmt.Sprintf("%s/%d-%08x.checkpoint", dir, folderNumber, h.Sum32()),
+		header:    header,
+		grids:     append(slices.Clip(grids), sidecars...),
+		report:    report,
+		remaining: make([]int, width),
+		done:      make([]bool, width),
+	}
+	for x := range c.remaining {
+		c.remaining[x] = height
+	}
+	return c
+}
+
+func (c *checkpoint) columnSize() int {
+	size := 0
+	for _, g := range c.grids {
+		size += g.Height * g.RecordSize
+	}
+	return size
+}
+
+// Loads whatever a previous run got through into the grids, then opens the file to carry on
+// appending. Returns how many columns were restored
+func (c *checkpoint) resume() (int, error) {
+	restored, validSize, err := c.load()
+	if err != nil {
+		return 0, err
+	}
+
+	if validSize == 0 {
+		c.file, err = os.Create(c.path)
+		if err == nil {
+			_, err = c.file.Write(c.header)
+		}
+	} else {
+		c.file, err = os.OpenFile(c.path, os.O_RDWR, 0o644)
+		if err == nil {
+			// Cut off a half written chunk so new ones line up
+			err = c.file.Truncate(validSize)
+		}
+		if err == nil {
+			_, err = c.file.Seek(validSize, io.SeekStart)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	c.w = bufio.NewWriterSize(c.file, 1<<20)
+	return restored, nil
+}
+
+func (c *checkpoint) load() (restored int, validSize int64, err error) {
+	file, err := os.Open(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReaderSize(file, 1<<20)
+
+	header := make([]byte, len(c.header))
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.Equal(header, c.header) {
+		fmt.Printf("Checkpoint %s is for something else, starting over\n", c.path)
+		return 0, 0, nil
+	}
+	validSize = int64(len(header))
+
+	height := c.grids[0].Height
+	fixed := 4 + c.columnSize()
+	var chunk []byte
+	for {
+		chunk, err = c.readChunk(r, chunk[:0], fixed)
+		if err != nil {
+			break
+		}
+
+		body := chunk[:len(chunk)-4]
+		if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(chunk[len(chunk)-4:]) {
+			break
+		}
+
+		x := int(binary.LittleEndian.Uint32(body))
+		if x >= len(c.done) {
+			break
+		}
+
+		off := 4
+		for _, g := range c.grids {
+			n := height * g.RecordSize
+			copy(g.Data[x*n:(x+1)*n], body[off:off+n])
+			off += n
+		}
+
+		bad := int(binary.LittleEndian.Uint32(body[off:]))
+		off += 4
+		for range bad {
+			y := int(binary.LittleEndian.Uint32(body[off:]))
+			n := int(binary.LittleEndian.Uint16(body[off+4:]))
+			c.report.setDetail(c.report.status.X+x, y, string(body[off+6:off+6+n]))
+			off += 6 + n
+		}
+
+		if !c.done[x] {
+			c.done[x] = true
+			c.remaining[x] = 0
+			restored++
+		}
+		validSize += int64(len(chunk))
+	}
+
+	return restored, validSize, nil
+}
+
+// One whole chunk, crc included, or an error if the file ends first. fixed is the x and the records,
+// how much is left after that depends on the bad tiles
+func (c *checkpoint) readChunk(r io.Reader, chunk []byte, fixed int) ([]byte, error) {
+	more := func(n int) error {
+		start := len(chunk)
+		chunk = append(chunk, make([]byte, n)...)
+		_, err := io.ReadFull(r, chunk[start:])
+		return err
+	}
+
+	if err := more(fixed + 4); err != nil {
+		return nil, err
+	}
+	bad := binary.LittleEndian.Uint32(chunk[fixed:])
+	if bad > uint32(c.grids[0].Height) {
+		return nil, fmt.Errorf("%d bad tiles in one column", bad)
+	}
+	for range bad {
+		if err := more(6); err != nil {
+			return nil, err
+		}
+		if err := more(int(binary.LittleEndian.Uint16(chunk[len(chunk)-2:]))); err != nil {
+			return nil, err
+		}
+	}
+	return chunk, more(4)
+}
+
+func (c *checkpoint) isDone(x int) bool {
+	return c.done[x]
+}
+
+// Called for every finished tile, a column gets queued for saving once its last tile is in
+func (c *checkpoint) tileDone(x int) {
+	c.remaining[x]--
+	if c.remaining[x] == 0 && !c.done[x] {
+		c.done[x] = true
+		c.pending = append(c.pending, x)
+	}
+}
+
+// For a column that comes back from a remote worker all at once
+func (c *checkpoint) columnDone(x int) {
+	if !c.done[x] {
+		c.remaining[x] = 0
+		c.done[x] = true
+		c.pending = append(c.pending, x)
+	}
+}
+
+// Appends every column finished since the last save and makes sure it's actually on disk
+func (c *checkpoint) save() error {
+	if len(c.pending) == 0 {
+		return nil
+	}
+
+	height := c.grids[0].Height
+	chunk := make([]byte, 0, 4+c.columnSize()+4)
+	for _, x := range c.pending {
+		chunk = binary.LittleEndian.AppendUint32(chunk[:0], uint32(x))
+		for _, g := range c.grids {
+			n := height * g.RecordSize
+			chunk = append(chunk, g.Data[x*n:(x+1)*n]...)
+		}
+		chunk = c.appendDetails(chunk, x)
+		chunk = binary.LittleEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk))
+
+		if _, err := c.w.Write(chunk); err != nil {
+			return err
+		}
+	}
+
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	if err := c.file.Sync(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Checkpoint saved, %d more columns\n", len(c.pending))
+	c.pending = c.pending[:0]
+	return nil
+}
+
+func (c *checkpoint) appendDetails(chunk []byte, x int) []byte {
+	status := c.report.status
+	count := len(chunk)
+	chunk = binary.LittleEndian.AppendUint32(chunk, 0)
+
+	bad := 0
+	for y := range status.Height {
+		if c.report.problem(x, y) == tileOK {
+			continue
+		}
+		ty := status.Y + y
+		detail := c.report.detail(status.X+x, ty)
+		if len(detail) > maxCheckpointDetail {
+			detail = detail[:maxCheckpointDetail]
+		}
+		chunk = binary.LittleEndian.AppendUint32(chunk, uint32(ty))
+		chunk = binary.LittleEndian.AppendUint16(chunk, uint16(len(detail)))
+		chunk = append(chunk, detail...)
+		bad++
+	}
+
+	binary.LittleEndian.PutUint32(chunk[count:], uint32(bad))
+	return chunk
+}
+
+// Once the outputs are written the checkpoint has done its job
+func (c *checkpoint) remove() {
+	c.file.Close()
+	if err := os.Remove(c.path); err != nil {
+		fmt.Fprintf(os.Stderr, "Error removing checkpoint %s: %v\n", c.path, err)
+	}
+}
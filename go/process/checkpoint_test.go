@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// A run's worth of state to checkpoint: one count grid and a report, over a 4x3 region
+type checkpointRun struct {
+	ops    []Operation
+	grid   *Grid
+	report *tileReport
+	cp     *checkpoint
+}
+
+var checkpointRegion = Region{X: 10, Y: 20, Width: 4, Height: 3}
+
+func newCheckpointRun(t *testing.T, dir string, operations string) *checkpointRun {
+	t.Helper()
+	ops, err := parseOperations(operations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &checkpointRun{ops: ops, grid: newGrid(checkpointRegion, ops[0].RecordSize()), report: newTileReport(checkpointRegion)}
+	r.cp = newCheckpoint(dir, 7, ops, []*Grid{r.grid}, []*Grid{r.report.status}, r.report)
+	return r
+}
+
+func (r *checkpointRun) resume(t *testing.T) int {
+	t.Helper()
+	restored, err := r.cp.resume()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.cp.file.Close() })
+	return restored
+}
+
+// Fills in column x, with a bad tile at y 21 on odd columns
+func (r *checkpointRun) finishColumn(x int) {
+	for y := range checkpointRegion.Height {
+		r.grid.At(x, y)[0] = byte(x*10 + y)
+		if x%2 == 1 && y == 1 {
+			r.report.status.At(x, y)[0] = byte(tileTruncated)
+			r.report.add(Result{x: checkpointRegion.X + x, y: checkpointRegion.Y + y, problem: tileTruncated, detail: "unexpected EOF"})
+		}
+		r.cp.tileDone(x)
+	}
+}
+
+func TestCheckpointResume(t *testing.T) {
+	tests := []struct {
+		name string
+		// What happens to the file after columns 0, 1 and 3 have been saved
+		damage   func(data []byte) []byte
+		restored int
+	}{
+		{"intact", func(data []byte) []byte { return data }, 3},
+		{"torn last chunk", func(data []byte) []byte { return data[:len(data)-5] }, 2},
+		{"only the header", func(data []byte) []byte { return data[:len(checkpointMagic)+4] }, 0},
+		{"bad crc", func(data []byte) []byte {
+			data[len(data)-1] ^= 0xff
+			return data
+		}, 2},
+		{"garbage after", func(data []byte) []byte { return append(data, 1, 2, 3) }, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			first := newCheckpointRun(t, dir, "c")
+			first.resume(t)
+			for _, x := range []int{0, 1, 3} {
+				first.finishColumn(x)
+			}
+			if err := first.cp.save(); err != nil {
+				t.Fatal(err)
+			}
+			first.cp.file.Close()
+
+			data, err := os.ReadFile(first.cp.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			damaged := tt.damage(data)
+			if err := os.WriteFile(first.cp.path, damaged, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			second := newCheckpointRun(t, dir, "c")
+			if got := second.resume(t); got != tt.restored {
+				t.Fatalf("restored %d columns, want %d", got, tt.restored)
+			}
+
+			done := 0
+			for x := range checkpointRegion.Width {
+				if !second.cp.isDone(x) {
+					continue
+				}
+				done++
+				n := checkpointRegion.Height * first.grid.RecordSize
+				if got, want := second.grid.Data[x*n:(x+1)*n], first.grid.Data[x*n:(x+1)*n]; !bytes.Equal(got, want) {
+					t.Errorf("column %d = %v, want %v", x, got, want)
+				}
+			}
+			if done != tt.restored {
+				t.Errorf("%d columns done, want %d", done, tt.restored)
+			}
+
+			// The bad tile in column 1 comes back with its message, so errors.csv is the same as
+			// if the run had never stopped
+			if second.cp.isDone(1) {
+				if p := second.report.problem(1, 1); p != tileTruncated {
+					t.Errorf("problem = %v, want truncated", p)
+				}
+				if d := second.report.detail(11, 21); d != "unexpected EOF" {
+					t.Errorf("detail = %q", d)
+				}
+			}
+
+			// A torn chunk gets cut off so new ones line up after the last good one
+			second.finishColumn(2)
+			if err := second.cp.save(); err != nil {
+				t.Fatal(err)
+			}
+			second.cp.file.Close()
+
+			third := newCheckpointRun(t, dir, "c")
+			if got := third.resume(t); got != tt.restored+1 {
+				t.Errorf("after another save restored %d, want %d", got, tt.restored+1)
+			}
+		})
+	}
+}
+
+func TestCheckpointOtherOperations(t *testing.T) {
+	dir := t.TempDir()
+
+	first := newCheckpointRun(t, dir, "c")
+	first.resume(t)
+	first.finishColumn(0)
+	if err := first.cp.save(); err != nil {
+		t.Fatal(err)
+	}
+	first.cp.file.Close()
+
+	// Different operations, different file
+	other := newCheckpointRun(t, dir, "c:scale=linear")
+	if other.cp.path == first.cp.path {
+		t.Fatal("both runs got the same checkpoint")
+	}
+	if got := other.resume(t); got != 0 {
+		t.Errorf("restored %d columns from another run's checkpoint", got)
+	}
+
+	// Same file name but a different header, it starts over rather than reading it
+	data, err := os.ReadFile(first.cp.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[0] = 'X'
+	if err := os.WriteFile(first.cp.path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	again := newCheckpointRun(t, dir, "c")
+	if got := again.resume(t); got != 0 {
+		t.Errorf("restored %d columns from a bad header", got)
+	}
+}
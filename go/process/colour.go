@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type RGB struct {
+	R uint8
+	G uint8
+	B uint8
+}
+
+type HSL struct {
+	H float64
+	S float64
+	L float64
+}
+
+func (rgb RGB) hex() string {
+	return fmt.Sprintf("#%02x%02x%02x", rgb.R, rgb.G, rgb.B)
+}
+
+func (rgb RGB) packed() uint32 {
+	return uint32(rgb.R)<<16 | uint32(rgb.G)<<8 | uint32(rgb.B)
+}
+
+func unpackRGB(packed uint32) RGB {
+	return RGB{R: byte(packed >> 16), G: byte(packed >> 8), B: byte(packed)}
+}
+
+func (rgb RGB) put(rec []byte) {
+	rec[0], rec[1], rec[2] = rgb.R, rgb.G, rgb.B
+}
+
+func rgbFromRecord(rec []byte) RGB {
+	return RGB{R: rec[0], G: rec[1], B: rec[2]}
+}
+
+// Accepts "ed1c24" or "#ed1c24"
+func parseHex(s string) (RGB, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 {
+		return RGB{}, fmt.Errorf("colour %q should be 6 hex digits", s)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return RGB{}, fmt.Errorf("colour %q: %w", s, err)
+	}
+	return RGB{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v)}, nil
+}
+
+// Adapted from stackoverflow.com/a/9493060/119527
+func hslToRgb(hsl HSL) RGB {
+	var r, g, b uint8
+	var h, s, l float64 = hsl.H, hsl.S, hsl.L
+	var q, p float64
+
+	if s == 0 {
+		gray := uint8(math.Round(l * 255))
+		r, g, b = gray, gray, gray
+	} else {
+		if l < 0.5 {
+			q = l * (1 + s)
+		} else {
+			q = l + s - l*s
+		}
+		p = 2*l - q
+		r = uint8(math.Round(float64(hueToRgb(p, q, h+1.0/3)) * 255))
+		g = uint8(math.Round(float64(hueToRgb(p, q, h)) * 255))
+		b = uint8(math.Round(float64(hueToRgb(p, q, h-1.0/3)) * 255))
+	}
+
+	return RGB{R: r, G: g, B: b}
+}
+
+func hueToRgb(p, q, t float64) float64 {
+	if t < 0 {
+		t += 1
+	}
+	if t > 1 {
+		t -= 1
+	}
+	if t < 1.0/6 {
+		return p + (q-p)*6*t
+	}
+	if t < 1.0/2 {
+		return q
+	}
+	if t < 2.0/3 {
+		return p + (q-p)*(2.0/3-t)*6
+	}
+	return p
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestParseHex(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    RGB
+		wantErr bool
+	}{
+		{in: "ed1c24", want: RGB{0xed, 0x1c, 0x24}},
+		{in: "#ED1C24", want: RGB{0xed, 0x1c, 0x24}},
+		{in: "000000", want: RGB{0, 0, 0}},
+		{in: "ffffff", want: RGB{0xff, 0xff, 0xff}},
+		{in: "", wantErr: true},
+		{in: "#fff", wantErr: true},
+		{in: "1234567", wantErr: true},
+		{in: "gg0000", wantErr: true},
+		{in: "##123456", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseHex(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseHex(%q) err = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("parseHex(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHexRoundTrip(t *testing.T) {
+	for _, c := range wplacePalette {
+		got, err := parseHex(c.RGB.hex())
+		if err != nil || got != c.RGB {
+			t.Errorf("%s: parseHex(%q) = %v, %v", c.Name, c.RGB.hex(), got, err)
+		}
+		if unpackRGB(c.RGB.packed()) != c.RGB {
+			t.Errorf("%s: packed round trip failed", c.Name)
+		}
+	}
+}
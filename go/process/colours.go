@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+func init() {
+	registerOperation(OperationSpec{
+		Key:    "p",
+		Params: append([]string{"x"}, rampParams...),
+		Usage:  "one map per palette colour of how many of its pixels each tile has (x=only these colours as hex joined by +, default every palette colour; 4 bytes per colour per tile in memory, so all of them is 252 bytes a tile; takes the count ramp params)",
+		New:    newColoursOperation,
+	})
+}
+
+// The record is a pixel count per picked colour, in the order they were asked for
+type coloursOperation struct {
+	pureOperation
+	name    string
+	colours []int // indices into wplacePalette
+	ramp    *colourRamp
+}
+
+func newColoursOperation(o Options) (Operation, error) {
+	op := &coloursOperation{name: "colours"}
+
+	if x, ok := o.Params["x"]; ok {
+		op.name += "-x" + strings.ReplaceAll(strings.ToLower(x), "+", "-")
+		for _, h := range strings.Split(x, "+") {
+			if h == "" {
+				continue
+			}
+			rgb, err := parseHex(h)
+			if err != nil {
+				return nil, err
+			}
+			i, ok := paletteIndex[rgb.packed()]
+			if !ok {
+				return nil, fmt.Errorf("colour %s isn't in the palette", rgb.hex())
+			}
+			op.colours = append(op.colours, i)
+		}
+		if len(op.colours) == 0 {
+			return nil, fmt.Errorf("x needs at least one colour")
+		}
+	} else {
+		for i := range wplacePalette {
+			op.colours = append(op.colours, i)
+		}
+	}
+
+	var err error
+	op.ramp, err = newColourRamp(o, tileSize*tileSize, "log", "magma")
+	if err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+func (op *coloursOperation) Name() string {
+	return op.name + op.ramp.suffix()
+}
+
+func (op *coloursOperation) RecordSize() int {
+	return len(op.colours) * 4
+}
+
+func (op *coloursOperation) Process(tile *Tile, rec []byte) error {
+	counts := make([]uint32, paletteSlots)
+	countByPalette(tile, counts)
+	for i, c := range op.colours {
+		binary.LittleEndian.PutUint32(rec[i*4:], counts[c])
+	}
+	return nil
+}
+
+// e.g. "Dark Green" -> "dark-green"
+func colourSlug(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), " ", "-")
+}
+
+// Every colour gets its own map named after it. Log and linear ramps don't depend on the data so
+// they share one legend, quantile ramps are fit to each colour and get one each
+func (op *coloursOperation) Encode(out *Output, grid *Grid) error {
+	counts := make([]uint32, grid.Width*grid.Height)
+
+	for i, c := range op.colours {
+		colour := wplacePalette[c]
+		for t := range counts {
+			counts[t] = binary.LittleEndian.Uint32(grid.Data[t*grid.RecordSize+i*4:])
+		}
+		op.ramp.fit(counts)
+
+		name := op.Name() + "-" + colourSlug(colour.Name)
+		err := out.SaveRGB(name, grid.Width, grid.Height, func(x, y int) RGB {
+			return op.ramp.colour(float64(counts[x*grid.Height+y]))
+		})
+		if err != nil {
+			return err
+		}
+
+		if op.ramp.scale == "quantile" {
+			title := fmt.Sprintf("%s (%s) pixels per tile", colour.Name, colour.RGB.hex())
+			if err := out.SavePNG(name+"-legend", op.ramp.legend(title, formatPixels)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if op.ramp.scale == "quantile" {
+		return nil
+	}
+	return out.SavePNG(op.Name()+"-legend", op.ramp.legend("pixels of the colour per tile", formatPixels))
+}
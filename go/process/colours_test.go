@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestColoursProcess(t *testing.T) {
+	// 3 red, 1 blue, 1 off palette, 1 transparent
+	img := testPaletted(3, 2, []RGB{red, blue, {1, 2, 3}}, []uint8{1, 1, 2, 1, 3, 0})
+
+	tests := []struct {
+		token string
+		want  map[int]uint32 // record slot -> count, everything else 0
+	}{
+		{"p", map[int]uint32{6: 3, 18: 1}},
+		{fmt.Sprintf("p:x=%s+%s+%s", blue.hex(), white.hex(), red.hex()), map[int]uint32{0: 1, 2: 3}},
+	}
+	for _, tt := range tests {
+		op, err := parseOperation(tt.token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, kind := range tileKinds {
+			rec := make([]byte, op.RecordSize())
+			if err := op.Process(testTile(t, img, kind.rgba), rec); err != nil {
+				t.Fatal(err)
+			}
+			for i := range len(rec) / 4 {
+				if got := binary.LittleEndian.Uint32(rec[i*4:]); got != tt.want[i] {
+					t.Errorf("%s %s: slot %d = %d, want %d", tt.token, kind.name, i, got, tt.want[i])
+				}
+			}
+		}
+	}
+}
+
+// One map per palette colour named after it, plus the shared legend
+func TestColoursFileNames(t *testing.T) {
+	op, err := parseOperation("p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &Output{Folder: 4, Dir: t.TempDir()}
+	if err := op.Encode(out, newGrid(Region{Width: 2, Height: 2}, op.RecordSize())); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"4-colours-legend.png"}
+	for _, c := range wplacePalette {
+		want = append(want, "4-colours-"+colourSlug(c.Name)+".png")
+	}
+	entries, err := os.ReadDir(out.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("files = %v\nwant %v", got, want)
+	}
+	if !slices.Contains(got, "4-colours-dark-green.png") {
+		t.Error("no dark-green map")
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Entropy goes through the ramp in millibits, edges in basis points like shares do
+const entropyScale = 1000
+
+func init() {
+	registerOperation(OperationSpec{
+		Key:    "e",
+		Params: append([]string{"p"}, rampShapeParams...),
+		Usage:  "complexity, colour entropy and edge density (the share of neighbouring painted pixel pairs that are different colours) per tile, as two maps and a csv (p=tiles with fewer painted pixels than this are left out, default 1000; maps take the ramp params except min/max, default linear/viridis for entropy and linear/magma for edges)",
+		New: func(o Options) (Operation, error) {
+			minPainted, err := o.Int("p", 1000)
+			if err != nil {
+				return nil, err
+			}
+			if minPainted < 1 {
+				return nil, fmt.Errorf("p (%d) has to be at least 1", minPainted)
+			}
+
+			maxEntropy := int(math.Ceil(math.Log2(float64(len(wplacePalette))) * entropyScale))
+			entropyRamp, err := newColourRamp(o, maxEntropy, "linear", "viridis")
+			if err != nil {
+				return nil, err
+			}
+			edgeRamp, err := newColourRamp(o, shareScale, "linear", "magma")
+			if err != nil {
+				return nil, err
+			}
+			return complexityOperation{minPainted: uint32(minPainted), entropyRamp: entropyRamp, edgeRamp: edgeRamp}, nil
+		},
+	})
+}
+
+// The record is the painted count, entropy in millibits and edge density in basis points. A flat fill
+// is 0 on both, noisy pixel art is high on both, and a big drawing in a few flat colours has some
+// entropy but hardly any edges. Tiles under minPainted are all zero, a handful of stray pixels would
+// otherwise light up as the most detailed thing on the map
+type complexityOperation struct {
+	pureOperation
+	minPainted            uint32
+	entropyRamp, edgeRamp *colourRamp
+}
+
+type tileComplexity struct {
+	painted, entropy, edges uint32
+}
+
+func (op complexityOperation) Name() string {
+	name := "complexity"
+	if op.minPainted != 1000 {
+		name += fmt.Sprintf("-p%d", op.minPainted)
+	}
+	return name
+}
+
+func (complexityOperation) RecordSize() int {
+	return 12
+}
+
+func (op complexityOperation) Process(tile *Tile, rec []byte) error {
+	var c tileComplexity
+	if tile.paletted() {
+		c = complexityPaletted(tile)
+	} else {
+		c = complexityRGBA(tile.Pix, tile.Width, tile.Height)
+	}
+	if c.painted < op.minPainted {
+		c = tileComplexity{painted: c.painted}
+	}
+
+	binary.LittleEndian.PutUint32(rec[0:4], c.painted)
+	binary.LittleEndian.PutUint32(rec[4:8], c.entropy)
+	binary.LittleEndian.PutUint32(rec[8:12], c.edges)
+	return nil
+}
+
+func complexityFromRecord(rec []byte) tileComplexity {
+	return tileComplexity{
+		painted: binary.LittleEndian.Uint32(rec[0:4]),
+		entropy: binary.LittleEndian.Uint32(rec[4:8]),
+		edges:   binary.LittleEndian.Uint32(rec[8:12]),
+	}
+}
+
+// Shannon entropy of the painted colours in bits, scaled to millibits
+func entropyOf(counts map[uint32]int, painted uint32) uint32 {
+	if painted == 0 {
+		return 0
+	}
+	var h float64
+	for _, n := range counts {
+		p := float64(n) / float64(painted)
+		h -= p * math.Log2(p)
+	}
+	return uint32(math.Round(h * entropyScale))
+}
+
+func edgeDensity(pairs, differ uint64) uint32 {
+	if pairs == 0 {
+		return 0
+	}
+	return uint32(differ * shareScale / pairs)
+}
+
+// Each pixel against the one to its right and the one below it, only pairs where both are painted
+func complexityPaletted(tile *Tile) tileComplexity {
+	counts := make(map[uint32]int, 64)
+	_, _, _, painted := paletteColourCounts(tile, counts)
+
+	// Two indices can be the same colour, so pairs get compared by colour. 0 is transparent,
+	// painted colours get bit 24 set so black is still told apart from it
+	var colours [256]uint32
+	for i, e := range tile.Palette {
+		if e.painted {
+			colours[i] = e.packed | 1<<24
+		}
+	}
+
+	var pairs, differ uint64
+	w, h := tile.Width, tile.Height
+	for y := range h {
+		row := tile.Index[y*w : (y+1)*w]
+		for x, idx := range row {
+			c := colours[idx]
+			if c == 0 {
+				continue
+			}
+			if x+1 < w {
+				if right := colours[row[x+1]]; right != 0 {
+					pairs++
+					if right != c {
+						differ++
+					}
+				}
+			}
+			if y+1 < h {
+				if below := colours[tile.Index[(y+1)*w+x]]; below != 0 {
+					pairs++
+					if below != c {
+						differ++
+					}
+				}
+			}
+		}
+	}
+
+	return tileComplexity{painted: uint32(painted), entropy: entropyOf(counts, uint32(painted)), edges: edgeDensity(pairs, differ)}
+}
+
+func complexityRGBA(pixels []uint8, width, height int) tileComplexity {
+	colourAt := func(i int) uint32 {
+		if pixels[i+3] == 0 {
+			return 0
+		}
+		return 1<<24 | uint32(pixels[i])<<16 | uint32(pixels[i+1])<<8 | uint32(pixels[i+2])
+	}
+
+	counts := make(map[uint32]int, 64)
+	var painted uint32
+	var pairs, differ uint64
+	for y := range height {
+		for x := range width {
+			i := (y*width + x) * 4
+			c := colourAt(i)
+			if c == 0 {
+				continue
+			}
+			counts[c&0xffffff]++
+			painted++
+
+			if x+1 < width {
+				if right := colourAt(i + 4); right != 0 {
+					pairs++
+					if right != c {
+						differ++
+					}
+				}
+			}
+			if y+1 < height {
+				if below := colourAt(i + width*4); below != 0 {
+					pairs++
+					if below != c {
+						differ++
+					}
+				}
+			}
+		}
+	}
+
+	return tileComplexity{painted: painted, entropy: entropyOf(counts, painted), edges: edgeDensity(pairs, differ)}
+}
+
+func (op complexityOperation) Encode(out *Output, grid *Grid) error {
+	if err := op.saveCSV(out.Path(op.Name(), "csv"), grid); err != nil {
+		return err
+	}
+
+	return saveTileMaps(out, grid, []tileMap{
+		{op.Name() + "-entropy" + op.entropyRamp.suffix(), op.entropyRamp, func(rec []byte) uint32 { return complexityFromRecord(rec).entropy }, "colour entropy per tile", func(v float64) string {
+			return fmt.Sprintf("%.2f bits", v/entropyScale)
+		}},
+		{op.Name() + "-edges" + op.edgeRamp.suffix(), op.edgeRamp, func(rec []byte) uint32 { return complexityFromRecord(rec).edges }, "share of neighbours that differ", func(v float64) string {
+			return fmt.Sprintf("%.1f%%", v*100/shareScale)
+		}},
+	})
+}
+
+// One row per tile with enough painted to count: x,y,painted,entropy,edges. Sorting it by either
+// column is the quickest way to find timelapse candidates
+func (op complexityOperation) saveCSV(outputPath string, grid *Grid) error {
+	return saveTileCSV(outputPath, "x,y,painted,entropy,edges", grid, func(x, y int, rec []byte) string {
+		c := complexityFromRecord(rec)
+		if c.painted < op.minPainted {
+			return ""
+		}
+		return fmt.Sprintf("%d,%.3f,%.4f", c.painted, float64(c.entropy)/entropyScale, float64(c.edges)/shareScale)
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestComplexityProcess(t *testing.T) {
+	const w, h = 4, 4
+	halves := make([]uint8, w*h)
+	checks := make([]uint8, w*h)
+	for y := range h {
+		for x := range w {
+			halves[y*w+x] = 1 + uint8(x*2/w)
+			checks[y*w+x] = 1 + uint8((x+y)%2)
+		}
+	}
+
+	// 4x4 has 12 horizontal and 12 vertical neighbour pairs, the split crosses 4 of them
+	tests := []struct {
+		name   string
+		pixels []uint8
+		want   tileComplexity
+	}{
+		{"flat", slices.Repeat([]uint8{1}, w*h), tileComplexity{painted: 16, entropy: 0, edges: 0}},
+		{"halves", halves, tileComplexity{painted: 16, entropy: entropyScale, edges: 4 * shareScale / 24}},
+		{"checkerboard", checks, tileComplexity{painted: 16, entropy: entropyScale, edges: shareScale}},
+	}
+
+	op := complexityOperation{minPainted: 1}
+	for _, tt := range tests {
+		img := testPaletted(w, h, []RGB{red, blue}, tt.pixels)
+
+		var records [][]byte
+		for _, kind := range tileKinds {
+			rec := make([]byte, op.RecordSize())
+			if err := op.Process(testTile(t, img, kind.rgba), rec); err != nil {
+				t.Fatal(err)
+			}
+			if got := complexityFromRecord(rec); got != tt.want {
+				t.Errorf("%s %s: got %+v, want %+v", tt.name, kind.name, got, tt.want)
+			}
+			records = append(records, rec)
+		}
+		if !slices.Equal(records[0], records[1]) {
+			t.Errorf("%s: paletted record %v, rgba record %v", tt.name, records[0], records[1])
+		}
+	}
+}
+
+// Transparent pixels aren't a colour and don't make an edge with whatever is next to them
+func TestComplexityIgnoresTransparent(t *testing.T) {
+	img := testPaletted(3, 1, []RGB{red}, []uint8{1, 0, 1})
+	for _, kind := range tileKinds {
+		rec := make([]byte, 12)
+		if err := (complexityOperation{minPainted: 1}).Process(testTile(t, img, kind.rgba), rec); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := complexityFromRecord(rec), (tileComplexity{painted: 2}); got != want {
+			t.Errorf("%s: got %+v, want %+v", kind.name, got, want)
+		}
+	}
+}
+
+func TestComplexityRampParams(t *testing.T) {
+	op, err := parseOperation("e:cmap=hsl:scale=log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := op.(complexityOperation)
+	if c.entropyRamp.cmap != "hsl" || c.edgeRamp.scale != "log" {
+		t.Errorf("ramps are %s/%s and %s/%s, want the params", c.entropyRamp.scale, c.entropyRamp.cmap, c.edgeRamp.scale, c.edgeRamp.cmap)
+	}
+	if _, err := parseOperation("e:min=5"); err == nil {
+		t.Error("min was accepted, it means nothing for entropy or edges")
+	}
+}
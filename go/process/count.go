@@ -0,0 +1,85 @@
+package main
+
+import "encoding/binary"
+
+func init() {
+	registerOperation(OperationSpec{
+		Key:    "c",
+		Params: rampParams,
+		Usage:  "count (" + rampUsage + ")",
+		New: func(o Options) (Operation, error) {
+			ramp, err := newColourRamp(o, tileSize*tileSize, "log", "hsl")
+			if err != nil {
+				return nil, err
+			}
+			return countOperation{ramp: ramp}, nil
+		},
+	})
+}
+
+// The record is the exact painted pixel count, the colour is only worked out when saving
+type countOperation struct {
+	pureOperation
+	ramp *colourRamp
+}
+
+func (op countOperation) Name() string {
+	return "count" + op.ramp.suffix()
+}
+
+func (countOperation) RecordSize() int {
+	return 4
+}
+
+func (countOperation) Process(tile *Tile, rec []byte) error {
+	binary.LittleEndian.PutUint32(rec, countTile(tile))
+	return nil
+}
+
+func (op countOperation) Encode(out *Output, grid *Grid) error {
+	counts := make([]uint32, grid.Width*grid.Height)
+	for i := range counts {
+		counts[i] = binary.LittleEndian.Uint32(grid.Data[i*4:])
+	}
+	op.ramp.fit(counts)
+
+	err := out.SaveRGB(op.Name(), grid.Width, grid.Height, func(x, y int) RGB {
+		return op.ramp.colour(float64(counts[x*grid.Height+y]))
+	})
+	if err != nil {
+		return err
+	}
+
+	return out.SavePNG(op.Name()+"-legend", op.ramp.legend("pixels painted per tile", formatPixels))
+}
+
+func countTile(tile *Tile) uint32 {
+	if tile.paletted() {
+		return countPaletted(tile.Index, tile.Palette)
+	}
+	return countRGBA(tile.Pix, tile.Width, tile.Height)
+}
+
+func countPaletted(index []uint8, palette *tilePalette) uint32 {
+	var totalCount uint32
+
+	for i, n := range indexCounts(index) {
+		if palette[i].painted {
+			totalCount += uint32(n)
+		}
+	}
+
+	return totalCount
+}
+
+func countRGBA(pixels []uint8, width, height int) uint32 {
+	var totalCount uint32
+
+	for i := 0; i < width*height*4; i += 4 {
+		if pixels[i+3] > 0 {
+			totalCount++
+		}
+	}
+
+	return totalCount
+}
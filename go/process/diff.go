@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+func init() {
+	registerOperation(OperationSpec{
+		Key:    "d",
+		Params: append([]string{"from"}, rampParams...),
+		Usage:  "pixels changed, appeared and erased since another folder (from=folder number, default the one before, which has to be extracted; also takes the count ramp params)",
+		New: func(o Options) (Operation, error) {
+			from, err := o.Int("from", 0)
+			if err != nil {
+				return nil, err
+			}
+			if from < 0 {
+				return nil, fmt.Errorf("from (%d) has to be a folder number", from)
+			}
+			ramp, err := newColourRamp(o, tileSize*tileSize, "log", "hsl")
+			if err != nil {
+				return nil, err
+			}
+			return diffOperation{from: from, ramp: ramp}, nil
+		},
+	})
+}
+
+// The record is changed, appeared and erased pixel counts. from = 0 means whichever folder came just
+// before the one being processed
+type diffOperation struct {
+	from int
+	ramp *colourRamp
+}
+
+func (op diffOperation) Name() string {
+	name := "diff"
+	if op.from > 0 {
+		name += fmt.Sprintf("-from%d", op.from)
+	}
+	return name + op.ramp.suffix()
+}
+
+func (diffOperation) RecordSize() int {
+	return 12
+}
+
+func (op diffOperation) fromFolder(folder int) int {
+	if op.from > 0 {
+		return op.from
+	}
+	return folder - 1
+}
+
+func (op diffOperation) ReadsFolder(folder int) int {
+	return op.fromFolder(folder)
+}
+
+func (op diffOperation) Prepare(out *Output) error {
+	from := op.fromFolder(out.Folder)
+	if from == out.Folder {
+		return fmt.Errorf("can't diff folder %d against itself", out.Folder)
+	}
+	_, err := openSnapshot(from)
+	return err
+}
+
+func (op diffOperation) Process(tile *Tile, rec []byte) error {
+	before, err := loadSnapshotTile(op.fromFolder(tile.Folder), tile.X, tile.Y)
+	if err != nil {
+		return err
+	}
+	putDiff(rec, diffTiles(before, tile))
+	return nil
+}
+
+// Empty now but painted before means it was wiped
+func (op diffOperation) ProcessEmpty(tile *Tile, rec []byte) error {
+	before, err := loadSnapshotTile(op.fromFolder(tile.Folder), tile.X, tile.Y)
+	if err != nil || before == nil {
+		return err
+	}
+	putDiff(rec, diffTiles(before, nil))
+	return nil
+}
+
+// Nothing can have changed against the folder before, anything further back needs the pixels
+func (op diffOperation) ProcessUnchanged(tile *Tile, prev, rec []byte) bool {
+	if op.fromFolder(tile.Folder) != tile.Folder-1 {
+		return false
+	}
+	clear(rec)
+	return true
+}
+
+// For operations that follow a range of folders, counts changes against the folder just before
+type previousFolder struct {
+	available bool
+}
+
+// Carrying on from earlier state needs the folder before. Starting fresh can do without,
+// changes just start being counted from the next folder
+func (p *previousFolder) prepare(folder int, required bool, what string) error {
+	_, err := openSnapshot(folder - 1)
+	p.available = err == nil
+	if required && err != nil {
+		return err
+	}
+	if !p.available {
+		fmt.Printf("Starting a new %s at folder %d, no changes are counted for it\n", what, folder)
+	}
+	return nil
+}
+
+// On a -u worker, where the coordinator has already decided whether to compare
+func (p *previousFolder) follow(folder int, compare bool) error {
+	p.available = false
+	if !compare {
+		return nil
+	}
+	if _, err := openSnapshot(folder - 1); err != nil {
+		return fmt.Errorf("the coordinator compares against folder %d: %w", folder-1, err)
+	}
+	p.available = true
+	return nil
+}
+
+// Pixels changed, appeared or erased since the folder before, tile has no pixels for an empty tile
+func (p *previousFolder) changed(tile *Tile) (uint32, error) {
+	if !p.available {
+		return 0, nil
+	}
+
+	before, err := loadSnapshotTile(tile.Folder-1, tile.X, tile.Y)
+	if err != nil {
+		return 0, err
+	}
+
+	after := tile
+	if tile.empty() {
+		after = nil
+	}
+	return diffTiles(before, after).total(), nil
+}
+
+type tileDiff struct {
+	changed, appeared, erased uint32
+}
+
+func (d tileDiff) total() uint32 {
+	return d.changed + d.appeared + d.erased
+}
+
+func putDiff(rec []byte, d tileDiff) {
+	binary.LittleEndian.PutUint32(rec[0:4], d.changed)
+	binary.LittleEndian.PutUint32(rec[4:8], d.appeared)
+	binary.LittleEndian.PutUint32(rec[8:12], d.erased)
+}
+
+func diffFromRecord(rec []byte) tileDiff {
+	return tileDiff{
+		changed:  binary.LittleEndian.Uint32(rec[0:4]),
+		appeared: binary.LittleEndian.Uint32(rec[4:8]),
+		erased:   binary.LittleEndian.Uint32(rec[8:12]),
+	}
+}
+
+// Either side can be nil for a tile that's empty there. changed = painted both times but a different colour
+func diffTiles(before, after *Tile) tileDiff {
+	var d tileDiff
+	switch {
+	case before == nil && after == nil:
+		return d
+	case before == nil:
+		d.appeared = countTile(after)
+		return d
+	case after == nil:
+		d.erased = countTile(before)
+		return d
+	case before.paletted() && after.paletted():
+		return diffPaletted(before, after)
+	}
+
+	a, b := before.rgba(), after.rgba()
+	for i := 0; i < len(a) && i < len(b); i += 4 {
+		wasPainted, isPainted := a[i+3] > 0, b[i+3] > 0
+		switch {
+		case wasPainted && isPainted:
+			if a[i] != b[i] || a[i+1] != b[i+1] || a[i+2] != b[i+2] {
+				d.changed++
+			}
+		case isPainted:
+			d.appeared++
+		case wasPainted:
+			d.erased++
+		}
+	}
+	return d
+}
+
+// The two tiles have their own palettes, so indices are only compared by the colours they stand for
+func diffPaletted(before, after *Tile) tileDiff {
+	var d tileDiff
+	pa, pb := before.Palette, after.Palette
+	for i := 0; i < len(before.Index) && i < len(after.Index); i++ {
+		was, is := pa[before.Index[i]], pb[after.Index[i]]
+		switch {
+		case was.painted && is.painted:
+			if was.packed != is.packed {
+				d.changed++
+			}
+		case is.painted:
+			d.appeared++
+		case was.painted:
+			d.erased++
+		}
+	}
+	return d
+}
+
+func (op diffOperation) Encode(out *Output, grid *Grid) error {
+	if err := op.saveCSV(out.Path(op.Name(), "csv"), grid); err != nil {
+		return err
+	}
+
+	title := fmt.Sprintf("pixels changed since folder %d", op.fromFolder(out.Folder))
+	return saveTileMaps(out, grid, []tileMap{
+		{op.Name(), op.ramp, func(rec []byte) uint32 { return diffFromRecord(rec).total() }, title, formatPixels},
+	})
+}
+
+// One row per tile where anything happened: x,y,changed,appeared,erased
+func (op diffOperation) saveCSV(outputPath string, grid *Grid) error {
+	return saveTileCSV(outputPath, "x,y,changed,appeared,erased", grid, func(x, y int, rec []byte) string {
+		d := diffFromRecord(rec)
+		if d.total() == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%d,%d,%d", d.changed, d.appeared, d.erased)
+	})
+}
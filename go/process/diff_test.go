@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestDiffTiles(t *testing.T) {
+	// Two tiles of 6 pixels with their own palettes, red is index 1 before and index 2 after
+	before := testPaletted(3, 2, []RGB{red, blue, white}, []uint8{1, 1, 2, 3, 0, 0})
+	after := testPaletted(3, 2, []RGB{blue, red, black}, []uint8{2, 1, 2, 0, 3, 0})
+	// pixel 0: red -> red, same colour under another index
+	// pixel 1: red -> blue, changed
+	// pixel 2: blue -> red, changed
+	// pixel 3: white -> nothing, erased
+	// pixel 4: nothing -> black, appeared
+	// pixel 5: nothing both times
+	want := tileDiff{changed: 2, appeared: 1, erased: 1}
+
+	for _, b := range tileKinds {
+		for _, a := range tileKinds {
+			t.Run(b.name+"-"+a.name, func(t *testing.T) {
+				got := diffTiles(testTile(t, before, b.rgba), testTile(t, after, a.rgba))
+				if got != want {
+					t.Errorf("diff = %+v, want %+v", got, want)
+				}
+			})
+		}
+	}
+
+	for _, kind := range tileKinds {
+		t.Run(kind.name+"-empty", func(t *testing.T) {
+			tile := testTile(t, before, kind.rgba)
+			if got := diffTiles(nil, tile); got != (tileDiff{appeared: 4}) {
+				t.Errorf("appeared = %+v", got)
+			}
+			if got := diffTiles(tile, nil); got != (tileDiff{erased: 4}) {
+				t.Errorf("erased = %+v", got)
+			}
+			if got := diffTiles(tile, testTile(t, before, !kind.rgba)); got != (tileDiff{}) {
+				t.Errorf("against itself = %+v", got)
+			}
+		})
+	}
+
+	if got := diffTiles(nil, nil); got != (tileDiff{}) {
+		t.Errorf("nothing either time = %+v", got)
+	}
+}
+
+func TestDiffPaletted(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after *Tile
+		want          tileDiff
+	}{
+		{
+			"same colour, different index",
+			testTile(t, testPaletted(2, 1, []RGB{red, blue}, []uint8{1, 2}), false),
+			testTile(t, testPaletted(2, 1, []RGB{blue, red}, []uint8{2, 1}), false),
+			tileDiff{},
+		},
+		{
+			"all painted over",
+			testTile(t, testPaletted(2, 1, []RGB{red}, []uint8{1, 1}), false),
+			testTile(t, testPaletted(2, 1, []RGB{blue}, []uint8{1, 1}), false),
+			tileDiff{changed: 2},
+		},
+		{
+			"painted black isn't transparent",
+			testTile(t, testPaletted(2, 1, []RGB{black}, []uint8{0, 1}), false),
+			testTile(t, testPaletted(2, 1, []RGB{black}, []uint8{1, 0}), false),
+			tileDiff{appeared: 1, erased: 1},
+		},
+	}
+	for _, tt := range tests {
+		if got := diffPaletted(tt.before, tt.after); got != tt.want {
+			t.Errorf("%s: %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Spreading a folder over several machines. The coordinator (-x) does everything a normal run does
+// except the tiles themselves, it hands out one column of tiles at a time over HTTP:
+//
+//	GET  /unit                 200 with a unit to do, 204 = nothing right now, try again, 410 = all done
+//	POST /unit/<folder>/<x>    the finished column, as a columnResult
+//
+// Workers (-u) need the same tiles extracted under their own -p, they only ever get told which ones
+// to do. So does the coordinator if an operation compares against other folders, it still checks
+// they're there before handing anything out. When the folder before is needed the unit says so, and a
+// worker without it stops instead of sending back columns where nothing ever changed.
+//
+// A column is leased for -k, if nothing comes back by then (the worker died, lost its connection,
+// etc.) it's handed out again. Whichever copy of a column comes back first wins.
+// There's no auth of any kind, keep it on a network you trust
+
+// How long a worker gets to send a column back before it goes to someone else. Set with -k
+var leaseTimeout = 2 * time.Minute
+
+// How long a worker waits before asking again when there's nothing to do
+const pollInterval = time.Second
+
+// Continuing is whether the coordinator's operations compare this folder against the one before
+// (see PreviousComparer), a worker has to do exactly the same or its columns won't add up
+type unit struct {
+	Folder     int    `json:"folder"`
+	X          int    `json:"x"`
+	Operations string `json:"operations"`
+	Region     Region `json:"region"`
+	Continuing bool   `json:"continuing"`
+}
+
+// Records and Status are that column's slice of each grid, Details is keyed by tile y
+type columnResult struct {
+	Records [][]byte       `json:"records"`
+	Status  []byte         `json:"status"`
+	Details map[int]string `json:"details"`
+}
+
+type coordinator struct {
+	operations string
+
+	mu         sync.Mutex
+	run        *folderRun // nil between folders
+	continuing bool
+	leased     []time.Time
+	done       []bool
+	allDone    bool
+
+	finished chan int
+}
+
+func runCoordinator(addr string, folders []int, ops []Operation, operations string, region Region) {
+	c := newCoordinator(operations)
+	server := &http.Server{Addr: addr, Handler: c.handler()}
+
+	go func() {
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}()
+	fmt.Printf("Coordinating on %s, start workers with -u http://<this machine>%s\n", addr, addr[strings.LastIndex(addr, ":"):])
+
+	c.coordinate(folders, ops, region)
+
+	// Long enough for every worker polling to hear it's over
+	time.Sleep(3 * pollInterval)
+	server.Close()
+}
+
+func newCoordinator(operations string) *coordinator {
+	return &coordinator{operations: operations}
+}
+
+func (c *coordinator) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /unit", c.serveUnit)
+	mux.HandleFunc("POST /unit/{folder}/{x}", c.receiveColumn)
+	return mux
+}
+
+// Every folder in turn, then tells anyone who asks that it's all done
+func (c *coordinator) coordinate(folders []int, ops []Operation, region Region) {
+	for _, folderNumber := range folders {
+		c.coordinateFolder(folderNumber, ops, region)
+	}
+
+	c.mu.Lock()
+	c.allDone = true
+	c.mu.Unlock()
+}
+
+func (c *coordinator) coordinateFolder(folderNumber int, ops []Operation, region Region) {
+	startTime := time.Now()
+
+	f := startFolder(folderNumber, ops, region)
+
+	continuing := false
+	for _, op := range ops {
+		if p, ok := op.(PreviousComparer); ok && p.ComparesPrevious() {
+			continuing = true
+		}
+	}
+
+	c.mu.Lock()
+	c.run = f
+	c.continuing = continuing
+	c.leased = make([]time.Time, region.Width)
+	c.done = make([]bool, region.Width)
+	c.finished = make(chan int, region.Width)
+	remaining := 0
+	for x := range region.Width {
+		c.done[x] = f.skipped(x)
+		if !c.done[x] {
+			remaining++
+		}
+	}
+	c.mu.Unlock()
+
+	total := remaining
+	fmt.Printf("Handing out %d columns of folder %d...\n", total, folderNumber)
+
+	var tick <-chan time.Time
+	if f.cp != nil {
+		ticker := time.NewTicker(checkpointEvery)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	for remaining > 0 {
+		select {
+		case x := <-c.finished:
+			remaining--
+			if f.cp != nil {
+				f.cp.columnDone(x)
+			}
+
+			if done := total - remaining; done%16 == 0 || remaining == 0 {
+				elapsed := time.Since(startTime)
+				eta := time.Duration(float64(elapsed) / float64(done) * float64(remaining))
+				fmt.Printf("Folder %d: %d/%d columns done - Elapsed: %v - ETA: %v\n",
+					folderNumber, done, total, elapsed.Round(time.Second), eta.Round(time.Second))
+			}
+
+		case <-tick:
+			saveCheckpoint(f.cp)
+
+		case <-interrupt:
+			if f.cp != nil {
+				saveCheckpoint(f.cp)
+			}
+			fmt.Println("Interrupted!")
+			os.Exit(130)
+		}
+	}
+
+	c.mu.Lock()
+	c.run = nil
+	c.mu.Unlock()
+
+	f.finish()
+	fmt.Printf("Folder %d took %v\n", folderNumber, time.Since(startTime).Round(time.Millisecond))
+}
+
+func (c *coordinator) serveUnit(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.allDone {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	if c.run == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	now := time.Now()
+	for x, deadline := range c.leased {
+		if c.done[x] || now.Before(deadline) {
+			continue
+		}
+		if !deadline.IsZero() {
+			fmt.Printf("Column %d of folder %d was never sent back, handing it out again\n", c.run.region.X+x, c.run.out.Folder)
+		}
+		c.leased[x] = now.Add(leaseTimeout)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(unit{
+			Folder:     c.run.out.Folder,
+			X:          c.run.region.X + x,
+			Operations: c.operations,
+			Region:     c.run.region,
+			Continuing: c.continuing,
+		})
+		return
+	}
+
+	// Everything left is out with someone, one of them might still die
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *coordinator) receiveColumn(w http.ResponseWriter, r *http.Request) {
+	folderNumber, err1 := strconv.Atoi(r.PathValue("folder"))
+	tx, err2 := strconv.Atoi(r.PathValue("x"))
+	if err1 != nil || err2 != nil {
+		http.Error(w, "bad folder or column", http.StatusBadRequest)
+		return
+	}
+
+	var res columnResult
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f := c.run
+	if f == nil || f.out.Folder != folderNumber {
+		// A slow worker finishing a column someone else already did, nothing to do with it
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	x := tx - f.region.X
+	if x < 0 || x >= f.region.Width {
+		http.Error(w, "column outside the region", http.StatusBadRequest)
+		return
+	}
+	if c.done[x] {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	height := f.region.Height
+	if len(res.Records) != len(f.grids) || len(res.Status) != height {
+		http.Error(w, "wrong number of records", http.StatusBadRequest)
+		return
+	}
+	for i, g := range f.grids {
+		if len(res.Records[i]) != height*g.RecordSize {
+			http.Error(w, fmt.Sprintf("wrong record size for %s", f.ops[i].Name()), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The column isn't done yet, so nothing else is looking at this part of the grids
+	for i, g := range f.grids {
+		n := height * g.RecordSize
+		copy(g.Data[x*n:(x+1)*n], res.Records[i])
+	}
+	copy(f.report.status.Data[x*height:(x+1)*height], res.Status)
+	for y, detail := range res.Details {
+		f.report.setDetail(tx, y, detail)
+	}
+
+	c.done[x] = true
+	c.finished <- x
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// A worker just keeps asking for columns until the coordinator says it's all done
+func runWorker(coordinatorURL string, numWorkers int, tilesPath func(folder int) string) {
+	coordinatorURL = strings.TrimSuffix(coordinatorURL, "/")
+	client := &http.Client{Timeout: time.Minute}
+
+	var (
+		operations string
+		ops        []Operation
+		prepared   = -1
+		wantEmpty  bool
+		failing    time.Time
+	)
+
+	// Coordinators come and go between runs, a minute of not hearing from one is long enough
+	retry := func(err error) bool {
+		if failing.IsZero() {
+			failing = time.Now()
+		}
+		if time.Since(failing) > time.Minute {
+			fmt.Fprintf(os.Stderr, "Error: giving up on %s: %v\n", coordinatorURL, err)
+			return false
+		}
+		fmt.Printf("Can't reach the coordinator (%v), trying again...\n", err)
+		time.Sleep(5 * pollInterval)
+		return true
+	}
+
+	for {
+		u, status, err := fetchUnit(client, coordinatorURL)
+		if err != nil {
+			if retry(err) {
+				continue
+			}
+			os.Exit(1)
+		}
+		failing = time.Time{}
+
+		switch status {
+		case http.StatusGone:
+			fmt.Println("Coordinator says everything's done!")
+			return
+		case http.StatusNoContent:
+			time.Sleep(pollInterval)
+			continue
+		}
+
+		// Operations keep state between folders, so they're only made again if they change
+		if u.Operations != operations {
+			ops, err = parseOperations(u.Operations)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			operations, prepared = u.Operations, -1
+		}
+
+		path := tilesPath(u.Folder)
+		if !exists(path) {
+			fmt.Fprintf(os.Stderr, "Error: folder %d isn't extracted here, looked in %s\n", u.Folder, path)
+			os.Exit(1)
+		}
+
+		// A folder's units all say the same about continuing, so preparing once per folder is enough.
+		// If it needs the folder before and that isn't here, Prepare fails rather than the worker
+		// sending back columns with no changes in them
+		if prepared != u.Folder {
+			out := &Output{Folder: u.Folder, Dir: outputFolderFor(u.Region), remote: true, continuing: u.Continuing}
+			wantEmpty = false
+			for _, op := range ops {
+				if p, ok := op.(Preparer); ok {
+					if err := p.Prepare(out); err != nil {
+						fmt.Fprintf(os.Stderr, "Error: %s: %v\n", op.Name(), err)
+						os.Exit(1)
+					}
+				}
+				if _, ok := op.(EmptyTileProcessor); ok {
+					wantEmpty = true
+				}
+			}
+			prepared = u.Folder
+		}
+
+		startTime := time.Now()
+		res := processColumn(u, ops, wantEmpty, numWorkers, path)
+
+		for {
+			err := sendColumn(client, coordinatorURL, u, res)
+			if err == nil {
+				break
+			}
+			if !retry(err) {
+				os.Exit(1)
+			}
+		}
+		failing = time.Time{}
+
+		fmt.Printf("Column %d of folder %d done in %v\n", u.X, u.Folder, time.Since(startTime).Round(time.Millisecond))
+	}
+}
+
+func fetchUnit(client *http.Client, coordinatorURL string) (unit, int, error) {
+	var u unit
+
+	resp, err := client.Get(coordinatorURL + "/unit")
+	if err != nil {
+		return u, 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		err = json.NewDecoder(resp.Body).Decode(&u)
+	case http.StatusNoContent, http.StatusGone:
+	default:
+		err = fmt.Errorf("coordinator said %s", resp.Status)
+	}
+	return u, resp.StatusCode, err
+}
+
+func sendColumn(client *http.Client, coordinatorURL string, u unit, res columnResult) error {
+	body, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(fmt.Sprintf("%s/unit/%d/%d", coordinatorURL, u.Folder, u.X), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusConflict:
+		// Conflict = someone else got there first, which is fine
+		return nil
+	default:
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("coordinator said %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+}
+
+// Same as a normal run, just for one column, into grids that only cover that column
+func processColumn(u unit, ops []Operation, wantEmpty bool, numWorkers int, path string) columnResult {
+	column := Region{X: u.X, Y: u.Region.Y, Width: 1, Height: u.Region.Height}
+
+	grids := make([]*Grid, len(ops))
+	for i, op := range ops {
+		grids[i] = newGrid(column, op.RecordSize())
+	}
+	status := newGrid(column, 1)
+
+	existing := preCheckExistingFiles(path, column)
+
+	jobs := make(chan Job, 100)
+	results := make(chan Result, 100)
+
+	var wg sync.WaitGroup
+	for range numWorkers {
+		wg.Add(1)
+		go worker(jobs, results, &wg, u.Folder, ops, grids, status, nil, path)
+	}
+
+	go func() {
+		defer close(jobs)
+		for y := column.Y; y < column.Y+column.Height; y++ {
+			if existing[fmt.Sprintf("%s/%d/%d.png", path, u.X, y)] {
+				jobs <- Job{x: u.X, y: y}
+			} else if wantEmpty {
+				jobs <- Job{x: u.X, y: y, empty: true}
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	res := columnResult{Status: status.Data, Details: make(map[int]string)}
+	for r := range results {
+		if r.problem != tileOK {
+			res.Details[r.y] = r.detail
+		}
+	}
+	for _, g := range grids {
+		res.Records = append(res.Records, g.Data)
+	}
+	return res
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Compares against the folder before (d, t), keeps state (t, g) and is plain per tile (c, m, s)
+const distributedTestOperations = "c m s:f=bin d t g"
+
+var distributedTestRegion = Region{X: 20, Y: 30, Width: 4, Height: 2}
+
+func writeDistributedTestFolders(t *testing.T, dir string) {
+	writeTestFolder(t, dir, 0, nil)
+	writeTestFolder(t, dir, 1, map[[2]int]image.Image{
+		{20, 30}: testWorldTile(1),
+		{21, 31}: testWorldTile(2),
+		{23, 30}: testWorldTile(3),
+	})
+	writeTestFolder(t, dir, 2, map[[2]int]image.Image{
+		{20, 30}: testWorldTile(1),
+		{21, 31}: testWorldTile(5),
+		{22, 30}: testWorldTile(6),
+		{23, 31}: nil,
+	})
+}
+
+func distributedOutputs(t *testing.T, dir string) map[string][]byte {
+	files := readOutputs(t, outputFolderFor(distributedTestRegion))
+	if len(files) == 0 {
+		t.Fatalf("no outputs in %s", dir)
+	}
+	return files
+}
+
+// A coordinator and two workers over localhost, one of which takes a column and dies with it. The
+// column has to be handed out again after the lease runs out, and everything has to come out the same
+// as a normal run
+func TestDistributedMatchesLocalRun(t *testing.T) {
+	oldLease := leaseTimeout
+	leaseTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { leaseTimeout = oldLease })
+
+	dir := useTestWorld(t, false)
+	writeDistributedTestFolders(t, dir)
+
+	ops, err := parseOperations(distributedTestOperations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newCoordinator(distributedTestOperations)
+	server := httptest.NewServer(c.handler())
+	defer server.Close()
+
+	coordinated := make(chan struct{})
+	go func() {
+		c.coordinate([]int{1, 2}, ops, distributedTestRegion)
+		close(coordinated)
+	}()
+
+	// The worker that dies: takes the first column and never sends it back
+	var lost unit
+	for {
+		u, status, err := fetchUnit(server.Client(), server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status == http.StatusOK {
+			lost = u
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Folder 0 is there, so the series counts changes against it from the start
+	if lost.Folder != 1 || lost.X != distributedTestRegion.X || !lost.Continuing {
+		t.Fatalf("first unit = %+v", lost)
+	}
+
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		runWorker(server.URL, 2, func(folder int) string {
+			return getTilesFolderPath(dir, folder, false)
+		})
+	})
+
+	select {
+	case <-coordinated:
+	case <-time.After(time.Minute):
+		t.Fatal("the coordinator never finished")
+	}
+	wg.Wait()
+
+	// The dead worker coming back to life much too late is turned away
+	late, _ := json.Marshal(columnResult{})
+	resp, err := server.Client().Post(server.URL+"/unit/1/20", "application/json", bytes.NewReader(late))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("late column got %s, want 409", resp.Status)
+	}
+
+	distributed := distributedOutputs(t, dir)
+
+	local := useTestWorld(t, false)
+	writeDistributedTestFolders(t, local)
+	ops, _ = parseOperations(distributedTestOperations)
+	for _, folder := range []int{1, 2} {
+		runProcess(folder, ops, distributedTestRegion, 2, TileSource{Path: getTilesFolderPath(local, folder, false)})
+	}
+	want := distributedOutputs(t, local)
+
+	for name, data := range want {
+		got, ok := distributed[name]
+		switch {
+		case !ok:
+			t.Errorf("%s is missing", name)
+		case !bytes.Equal(got, data):
+			t.Errorf("%s differs from a local run", name)
+		}
+	}
+	for name := range distributed {
+		if _, ok := want[name]; !ok {
+			t.Errorf("%s is only there in the distributed run", name)
+		}
+	}
+}
+
+// A worker goes by what the unit says about the folder before, not by what it happens to have
+func TestSeriesFollowsCoordinator(t *testing.T) {
+	dir := useTestWorld(t, false)
+	writeTestFolder(t, dir, 4, nil)
+
+	tests := []struct {
+		folder     int
+		continuing bool
+		compares   bool
+		err        string
+	}{
+		{5, true, true, ""},
+		{5, false, false, ""}, // folder 4 is there, but the coordinator started fresh
+		{6, false, false, ""},
+		{6, true, false, "the coordinator compares against folder 5"},
+	}
+	for _, tt := range tests {
+		op, _ := parseOperation("t")
+		err := op.(Preparer).Prepare(&Output{Folder: tt.folder, Dir: dir, remote: true, continuing: tt.continuing})
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("folder %d: error %v, want one containing %q", tt.folder, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("folder %d: %v", tt.folder, err)
+			continue
+		}
+		if got := op.(PreviousComparer).ComparesPrevious(); got != tt.compares {
+			t.Errorf("folder %d continuing %v: compares = %v", tt.folder, tt.continuing, got)
+		}
+	}
+}
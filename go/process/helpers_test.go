@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A paletted image the way wplace saves them, index 0 transparent and the rest painted.
+// pixels are palette indices, row by row
+func testPaletted(width, height int, palette []RGB, pixels []uint8) *image.Paletted {
+	p := color.Palette{color.NRGBA{}}
+	for _, c := range palette {
+		p = append(p, color.NRGBA{c.R, c.G, c.B, 255})
+	}
+	img := image.NewPaletted(image.Rect(0, 0, width, height), p)
+	copy(img.Pix, pixels)
+	return img
+}
+
+func encodePNG(t testing.TB, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Goes through an actual PNG, so the tile comes out exactly like it would from disk. rgba expands
+// it the way -n does
+func testTile(t testing.TB, img image.Image, rgba bool) *Tile {
+	t.Helper()
+	old := forceRGBA
+	forceRGBA = rgba
+	defer func() { forceRGBA = old }()
+
+	tile, err := decodeTile(bytes.NewReader(encodePNG(t, img)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tile.paletted() == rgba {
+		t.Fatalf("wanted paletted = %v", !rgba)
+	}
+	return tile
+}
+
+// Both ways a tile can come out of decodeTile
+var tileKinds = []struct {
+	name string
+	rgba bool
+}{
+	{"paletted", false},
+	{"rgba", true},
+}
+
+// A full size tile that looks roughly like a real one: a third empty, the rest rectangles of a dozen
+// palette colours with some single pixel noise on top. Same seed, same tile
+func testWorldTile(seed uint64) *image.Paletted {
+	rng := rand.New(rand.NewPCG(seed, 0))
+	var colours []RGB
+	for range 12 {
+		colours = append(colours, wplacePalette[rng.IntN(len(wplacePalette))].RGB)
+	}
+	img := testPaletted(tileSize, tileSize, colours, nil)
+
+	for range 300 {
+		x, y := rng.IntN(tileSize), rng.IntN(tileSize)
+		w, h := 10+rng.IntN(120), 10+rng.IntN(120)
+		idx := uint8(1 + rng.IntN(len(colours)))
+		for yy := y; yy < min(y+h, tileSize*2/3); yy++ {
+			for xx := x; xx < min(x+w, tileSize); xx++ {
+				img.Pix[yy*tileSize+xx] = idx
+			}
+		}
+	}
+	for range 20_000 {
+		img.Pix[rng.IntN(tileSize*tileSize)] = uint8(rng.IntN(len(colours) + 1))
+	}
+	return img
+}
+
+// Points wplacePath, and the snapshots found through it, at a fresh temp folder for the length of
+// the test. Checkpoints are off, incremental is -i
+func useTestWorld(t *testing.T, incr bool) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	oldPath, oldIncremental, oldEvery, oldRoots := wplacePath, incremental, checkpointEvery, snapshotRoots
+	wplacePath, incremental, checkpointEvery = dir, incr, 0
+	snapshotRoots = func(folder int) []string {
+		return []string{getTilesFolderPath(dir, folder, false)}
+	}
+	forgetSnapshots()
+
+	t.Cleanup(func() {
+		wplacePath, incremental, checkpointEvery, snapshotRoots = oldPath, oldIncremental, oldEvery, oldRoots
+		forgetSnapshots()
+	})
+	return dir
+}
+
+func forgetSnapshots() {
+	snapshotMu.Lock()
+	clear(snapshotFound)
+	snapshotMu.Unlock()
+}
+
+// Writes <dir>/tiles-N/tiles-N/x/y.png the way an extracted folder looks, a nil image is a file that
+// isn't a png at all. Returns the tiles folder
+func writeTestFolder(t *testing.T, dir string, folder int, tiles map[[2]int]image.Image) string {
+	t.Helper()
+	root := getTilesFolderPath(dir, folder, false)
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for c, img := range tiles {
+		data := []byte("not a png")
+		if img != nil {
+			data = encodePNG(t, img)
+		}
+		column := fmt.Sprintf("%s/%d", root, c[0])
+		if err := os.MkdirAll(column, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fmt.Sprintf("%s/%d.png", column, c[1]), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// Every file a run left in dir, name to contents
+func readOutputs(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[e.Name()] = data
+	}
+	return files
+}
@@ -1,52 +1,60 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"flag"
 	"fmt"
-	"image"
-	"image/draw"
-	"image/png"
-	"math"
 	"os"
-	"os/exec"
+	"os/signal"
 	"strings"
 	"sync"
 	"time"
+
+	"tilearchive"
 )
 
-type RGB struct {
-	R uint8
-	G uint8
-	B uint8
+// data is only set when the tile came out of an archive, otherwise the worker reads x/y.png itself.
+// err is set if it couldn't even be read out of the archive. empty tiles only become jobs when an
+// operation wants to see them
+type Job struct {
+	x, y  int
+	data  []byte
+	err   error
+	empty bool
 }
 
-type HSL struct {
-	H float64
-	S float64
-	L float64
+type Result struct {
+	x, y    int
+	problem tileProblem
+	detail  string
 }
 
-type ProcessOpts struct {
-	IncludeTransparency bool
-	IncludeBoring       bool
-}
+var wplacePath string = "C:/Users/jazza/Downloads/wplace"
 
-type Job struct {
-	x, y int
-}
+// Painted over bad tiles on every map, nil = leave them as they are
+var markerColour *RGB
 
-type Result struct {
-	x, y int
-	rgb  RGB
+// How often finished columns get saved so a crashed or interrupted run can pick up where it left off
+var checkpointEvery = time.Minute
+
+// Where a folder's tiles come from, either a folder of x/y.png or straight out of tiles-N.7z
+type TileSource struct {
+	Path    string
+	Archive *tilearchive.Archive
 }
 
-var wplacePath string = "C:/Users/jazza/Downloads/wplace"
+func (src TileSource) String() string {
+	if src.Archive != nil {
+		return src.Archive.Path
+	}
+	return src.Path
+}
 
-func preCheckExistingFiles(basepath string, width int) map[string]bool {
+func preCheckExistingFiles(basepath string, region Region) map[string]bool {
 	existing := make(map[string]bool)
 
-	for x := range width {
+	for x := region.X; x < region.X+region.Width; x++ {
 		dirPath := fmt.Sprintf("%s/%d", basepath, x)
 		if entries, err := os.ReadDir(dirPath); err == nil {
 			for _, entry := range entries {
@@ -63,12 +71,20 @@ func preCheckExistingFiles(basepath string, width int) map[string]bool {
 func main() {
 	folderStart := 1
 	folderEnd := -1
-	width, height := 2048, 2048
 	numWorkers := 16
 	singleFolder := false
 	extract := false
+	stream := false
+	inFlight := 0
+	budgetGiB := 0.0
 	tempPath := os.TempDir()
 	operations := "c m"
+	dataFormat := ""
+	marker := ""
+	regionString := ""
+	around := 8
+	coordinate := ""
+	coordinatorURL := ""
 
 	flag.IntVar(&folderStart, "f", folderStart, "The folder number to start processing at")
 	flag.IntVar(&folderEnd, "l", folderEnd, "The folder number to end processing at. Omit or set to -1 to process only 1 folder")
@@ -76,10 +92,85 @@ func main() {
 	flag.StringVar(&wplacePath, "p", wplacePath, "The path to the wplace folder, namely the folder containing the tiles-x folder")
 	flag.BoolVar(&singleFolder, "s", singleFolder, "Whether the archive is tiles-x.7z/tiles-x or just tiles-x.7z")
 	flag.BoolVar(&extract, "e", extract, "Whether to extract the archive automatically or not")
+	flag.BoolVar(&stream, "z", stream, "Read tiles straight out of tiles-x.7z instead, nothing gets extracted or written to the temp folder")
+	flag.IntVar(&inFlight, "j", inFlight, "With -e, how many folders can be extracted at once. Each one is deleted as soon as it's processed, or once the next one is if an operation compares against it. 0 extracts everything up front")
+	flag.Float64Var(&budgetGiB, "b", budgetGiB, "With -j, the most disk space in GiB extracted folders can take up at once. 0 = no limit")
 	flag.StringVar(&tempPath, "t", tempPath, "The path to the temporary folder to extract the archive to")
-	flag.StringVar(&operations, "o", operations, "The operations: c=count, m=mode, a=average, modifiers: t=transparent, b=boring")
+	flag.StringVar(&operations, "o", operations, "The operations: "+operationsUsage())
+	flag.StringVar(&dataFormat, "d", dataFormat, "Also save raw per-tile stats (painted, unique, mode, average) as csv, jsonl or bin. Omit to skip")
+	flag.DurationVar(&checkpointEvery, "c", checkpointEvery, "How often to checkpoint finished columns to the data folder, rerunning the same folder and operations resumes from it. 0 to turn off")
+	flag.StringVar(&marker, "m", marker, "Paint tiles that are missing, truncated, undecodable or the wrong size this hex colour on every map, e.g. ff00ff. Omit to leave them black")
+	flag.StringVar(&regionString, "r", regionString, "Only process these tiles, either left,top,right,bottom (inclusive) or a wplace.live link. Outputs go in data/region-<x>-<y>-<w>x<h>. Omit for the whole world")
+	flag.IntVar(&around, "a", around, "With a link for -r, how many tiles either side of the link's tile to include")
+	flag.BoolVar(&incremental, "i", incremental, "Keep a hash of every tile and every operation's results in the data folder, and reuse the folder before's results for tiles that haven't changed. Only works with the same operations and region as the folder before")
+	flag.BoolVar(&forceRGBA, "n", forceRGBA, "Expand paletted tiles to RGBA instead of working on their palette indices. Slower, only useful to compare the pixels/second against")
+	flag.StringVar(&coordinate, "x", coordinate, "Coordinate workers on other machines instead of processing here, listening on this address, e.g. :8090. Every other flag works as usual, the outputs end up here")
+	flag.StringVar(&coordinatorURL, "u", coordinatorURL, "Be a worker for the coordinator at this URL, e.g. http://10.0.0.2:8090. Only -p, -s, -w and -n matter, everything else comes from the coordinator")
+	flag.DurationVar(&leaseTimeout, "k", leaseTimeout, "With -x, how long a worker gets to send a column back before it's handed to another worker")
+	flag.Float64Var(&sampleRate, "q", sampleRate, "Only process this fraction of the existing tiles, picked at random in 64x64 tile blocks, and estimate the folder's totals from them instead of running -o. Outputs go in data/sample-<rate>. 0 to process everything")
+	flag.BoolVar(&samplePreview, "v", samplePreview, "With -q, also save a rough painted count map, every tile that wasn't sampled gets its block's average")
 	flag.Parse()
 
+	region := worldRegion()
+	if regionString != "" {
+		var err error
+		region, err = parseRegion(regionString, around)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Only processing tiles %d,%d to %d,%d\n", region.X, region.Y, region.X+region.Width-1, region.Y+region.Height-1)
+	}
+
+	if sampleRate < 0 || sampleRate > 1 {
+		fmt.Fprintf(os.Stderr, "Error: sample rate (%g) has to be between 0 and 1\n", sampleRate)
+		os.Exit(1)
+	}
+	if sampleRate > 0 {
+		if incremental || coordinate != "" {
+			fmt.Fprintln(os.Stderr, "Error: -q doesn't work with -i or -x")
+			os.Exit(1)
+		}
+		// A sample is over in seconds, there's nothing worth resuming
+		checkpointEvery = 0
+	}
+
+	if marker != "" {
+		rgb, err := parseHex(marker)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: marker colour: %v\n", err)
+			os.Exit(1)
+		}
+		markerColour = &rgb
+	}
+
+	// Operations that compare snapshots read the other folder's tiles from wherever it's extracted
+	snapshotRoots = func(folder int) []string {
+		return []string{getTilesFolderPath(wplacePath, folder, singleFolder), fmt.Sprintf("%s/tiles-%d", tempPath, folder)}
+	}
+
+	if coordinatorURL != "" {
+		runWorker(coordinatorURL, numWorkers, func(folder int) string {
+			return getTilesFolderPath(wplacePath, folder, singleFolder)
+		})
+		return
+	}
+
+	// -d is kept as a shorthand for the stats operation
+	if dataFormat != "" {
+		operations += " s:f=" + dataFormat
+	}
+
+	ops, err := parseOperations(operations)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := checkRegion(ops, region); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	tilesByFolder := make(map[int]string)
 	extractWorkers := 8
 
@@ -87,12 +178,48 @@ func main() {
 		folderEnd = folderStart
 	}
 
-	{
-		var mutex sync.Mutex
+	if coordinate != "" {
+		if incremental {
+			fmt.Fprintln(os.Stderr, "Error: -i doesn't work with -x, workers don't send hashes back")
+			os.Exit(1)
+		}
+
 		var folders []int
 		for folderNum := folderStart; folderNum <= folderEnd; folderNum++ {
 			folders = append(folders, folderNum)
 		}
+		runCoordinator(coordinate, folders, ops, operations, region)
+		return
+	}
+
+	if stream {
+		for folderNum := folderStart; folderNum <= folderEnd; folderNum++ {
+			archive, err := tilearchive.Open(tilearchive.ArchivePath(wplacePath, folderNum))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			runProcess(folderNum, ops, region, numWorkers, TileSource{Archive: archive})
+			archive.Close()
+		}
+		return
+	}
+
+	var folders []int
+	for folderNum := folderStart; folderNum <= folderEnd; folderNum++ {
+		folders = append(folders, folderNum)
+	}
+
+	if extract && inFlight > 0 {
+		budget := newDiskBudget(int64(budgetGiB*(1<<30)), inFlight)
+		runPipeline(folders, ops, tempPath, budget, func(folderNum int, p string) {
+			runProcess(folderNum, ops, region, numWorkers, TileSource{Path: p})
+		})
+		return
+	}
+
+	{
+		var mutex sync.Mutex
 
 		runWorkers(folders, extractWorkers, func(folderNum int) {
 			var p string
@@ -108,16 +235,7 @@ func main() {
 	}
 
 	for folderNum := folderStart; folderNum <= folderEnd; folderNum++ {
-		p := tilesByFolder[folderNum]
-		operationFuncs, err := chooseOperations(operations)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
-		}
-
-		for _, fn := range operationFuncs {
-			fn(folderNum, width, height, numWorkers, p)
-		}
+		runProcess(folderNum, ops, region, numWorkers, TileSource{Path: tilesByFolder[folderNum]})
 	}
 
 	{
@@ -134,40 +252,6 @@ func main() {
 	}
 }
 
-// This was actually so fun to figure out, idk if I have ever returned functions in code before
-func chooseOperations(operationsString string) ([]func(folderNum, width, height, numWorkers int, p string), error) {
-	specs := map[string]struct {
-		name string
-		opts ProcessOpts
-	}{
-		"c":  {"count", ProcessOpts{IncludeBoring: false, IncludeTransparency: false}},
-		"m":  {"mode", ProcessOpts{IncludeBoring: false, IncludeTransparency: false}},
-		"a":  {"average", ProcessOpts{IncludeBoring: false, IncludeTransparency: false}},
-		"mb": {"mode", ProcessOpts{IncludeBoring: true, IncludeTransparency: false}},
-		"at": {"average", ProcessOpts{IncludeBoring: false, IncludeTransparency: true}},
-	}
-
-	tokens := strings.FieldsFunc(operationsString, func(r rune) bool { return r == ',' || r == ' ' })
-
-	functions := make([]func(folderNum, width, height, numWorkers int, p string), 0, len(tokens))
-	for _, t := range tokens {
-		spec, ok := specs[t]
-		if !ok {
-			return nil, fmt.Errorf("unknown operation %q", t)
-		}
-		name := spec.name
-		opts := spec.opts
-		functions = append(functions, func(folderNum, width, height, numWorkers int, p string) {
-			runProcess(folderNum, name, width, height, numWorkers, p, opts)
-		})
-	}
-
-	if len(functions) == 0 {
-		return nil, errors.New("no operations specified")
-	}
-	return functions, nil
-}
-
 func runWorkers[T any](items []T, numWorkers int, fn func(T)) {
 	if numWorkers < 1 {
 		numWorkers = 1
@@ -192,29 +276,29 @@ func runWorkers[T any](items []T, numWorkers int, fn func(T)) {
 }
 
 func getTilesFolderPath(wplaceOrTemp string, folderNumber int, singleFolder bool) (tilesFolderPath string) {
-	if singleFolder {
-		tilesFolderPath = fmt.Sprintf("%s/tiles-%d", wplaceOrTemp, folderNumber)
-	} else {
-		tilesFolderPath = fmt.Sprintf("%s/tiles-%d/tiles-%d", wplaceOrTemp, folderNumber, folderNumber)
+	return tilearchive.FolderPath(wplaceOrTemp, folderNumber, singleFolder)
+}
+
+func extractTiles(tempPath string, folderNumber int) (tilesFolderPath string) {
+	archive, err := tilearchive.Open(tilearchive.ArchivePath(wplacePath, folderNumber))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
 	}
+	defer archive.Close()
 
-	return tilesFolderPath
+	return extractArchive(tempPath, folderNumber, archive)
 }
 
-func extractTiles(tempPath string, folderNumber int) (tilesFolderPath string) {
+func extractArchive(tempPath string, folderNumber int, archive *tilearchive.Archive) (tilesFolderPath string) {
 	if !exists(tempPath) {
 		fmt.Printf("Creating temp path %s...\n", tempPath)
 		os.Mkdir(tempPath, os.ModePerm)
 	}
 
-	extractFrom := fmt.Sprintf("%s/tiles-%d.7z", wplacePath, folderNumber)
+	fmt.Printf("Extracting %s to %s\n", archive.Path, tempPath)
 
-	fmt.Printf("Extracting %s to %s\n", extractFrom, tempPath)
-	sevenZArgs := []string{"x", "-o" + tempPath, extractFrom}
-
-	err := exec.Command("7z", sevenZArgs...).Run()
-
-	if err != nil {
+	if err := archive.Extract(tempPath, 4); err != nil {
 		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
@@ -234,66 +318,262 @@ func deleteTilesFolder(tilesFolderPath string) {
 	fmt.Println("Done!")
 }
 
-func runProcess(folderNumber int, processor string, width, height, numWorkers int, tilesFolderPath string, opts ProcessOpts) {
+// Everything a folder's outputs are made from, whether its tiles get processed here or by workers
+// somewhere else (see distributed.go)
+type folderRun struct {
+	out       *Output
+	region    Region
+	ops       []Operation
+	grids     []*Grid
+	report    *tileReport
+	cache     *tileCache
+	cp        *checkpoint
+	restored  int
+	wantEmpty bool
+}
+
+// A region gets its own folder, so its outputs, states and checkpoints never get mixed up with the
+// whole world's or another region's
+func outputFolderFor(region Region) string {
+	outputFolder := fmt.Sprintf("%s/data", wplacePath)
+	if !region.isWorld() {
+		outputFolder = fmt.Sprintf("%s/region-%s", outputFolder, region)
+	}
+	if sampleRate > 0 {
+		outputFolder = fmt.Sprintf("%s/%s", outputFolder, sampleFolderName())
+	}
+	return outputFolder
+}
+
+// Sets up the grids, picks up a checkpoint if there is one and lets the operations prepare
+func startFolder(folderNumber int, ops []Operation, region Region) *folderRun {
+	outputFolder := outputFolderFor(region)
+	if !exists(outputFolder) {
+		fmt.Printf("Creating output folder %s...\n", outputFolder)
+		os.MkdirAll(outputFolder, os.ModePerm)
+	}
+
+	f := &folderRun{region: region, ops: ops, grids: make([]*Grid, len(ops))}
+	for i, op := range ops {
+		f.grids[i] = newGrid(region, op.RecordSize())
+	}
+
+	f.report = newTileReport(region)
+	f.out = &Output{Folder: folderNumber, Dir: outputFolder, Marker: markerColour, report: f.report}
+
+	sidecars := []*Grid{f.report.status}
+	if incremental {
+		f.cache = newTileCache(f.out, ops, region)
+		sidecars = append(sidecars, f.cache.hashes)
+	}
+
+	for _, op := range ops {
+		if p, ok := op.(Preparer); ok {
+			if err := p.Prepare(f.out); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s: %v\n", op.Name(), err)
+				os.Exit(1)
+			}
+		}
+		if _, ok := op.(EmptyTileProcessor); ok {
+			f.wantEmpty = true
+		}
+	}
+
+	// Only once nothing's failed, a run that never got started shouldn't leave a checkpoint behind
+	if checkpointEvery > 0 {
+		f.cp = newCheckpoint(outputFolder, folderNumber, ops, f.grids, sidecars, f.report)
+		restored, err := f.cp.resume()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening checkpoint: %v\n", err)
+			os.Exit(1)
+		}
+		if restored > 0 {
+			fmt.Printf("Resuming from %s, %d/%d columns already done\n", f.cp.path, restored, region.Width)
+		}
+		f.restored = restored
+	}
+
+	return f
+}
+
+// Column x (relative to the region) was finished by a previous run
+func (f *folderRun) skipped(x int) bool {
+	return f.cp != nil && f.cp.isDone(x)
+}
+
+// Writes the report and every operation's outputs once all the tiles are in
+func (f *folderRun) finish() {
+	bad, err := f.report.save(f.out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving tile report: %v\n", err)
+		os.Exit(1)
+	}
+	if bad > 0 {
+		fmt.Printf("%d bad tiles, see %s\n", bad, f.out.Path("errors", "csv"))
+	}
+
+	for i, op := range f.ops {
+		if err := op.Encode(f.out, f.grids[i]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving %s: %v\n", op.Name(), err)
+			os.Exit(1)
+		}
+	}
+
+	if f.cache != nil {
+		if err := f.cache.save(f.out, f.ops, f.grids); err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving cache: %v\n", err)
+			os.Exit(1)
+		}
+		if n := f.cache.reused.Load(); n > 0 {
+			fmt.Printf("Reused %d unchanged tiles from folder %d\n", n, f.out.Folder-1)
+		}
+	}
+
+	if f.cp != nil {
+		f.cp.remove()
+	}
+}
+
+func runProcess(folderNumber int, ops []Operation, region Region, numWorkers int, src TileSource) {
 	startTime := time.Now()
 
-	if !exists(tilesFolderPath) {
-		fmt.Printf("Folder \"%s\" does not exist!\n", tilesFolderPath)
+	if src.Archive == nil && !exists(src.Path) {
+		fmt.Printf("Folder \"%s\" does not exist!\n", src.Path)
 		os.Exit(1)
 	}
 
+	// Sampling swaps the operations for the estimate, which needs to know which tiles exist first
+	var sample *tileSample
+	if sampleRate > 0 {
+		has := func(x, y int) bool { return src.Archive.Has(x, y) }
+		if src.Archive == nil {
+			existingFiles := preCheckExistingFiles(src.Path, region)
+			has = func(x, y int) bool { return existingFiles[fmt.Sprintf("%s/%d/%d.png", src.Path, x, y)] }
+		}
+		sample = newTileSample(folderNumber, region, sampleRate, has)
+		ops = []Operation{newEstimateOperation(sample)}
+		fmt.Printf("Sampling %d of the folder's %d tiles, -o isn't used\n", len(sample.picked), countExisting(sample))
+	}
+
+	f := startFolder(folderNumber, ops, region)
+	grids, report, cp := f.grids, f.report, f.cp
+
 	jobs := make(chan Job, 1000)
 	results := make(chan Result, 1000)
-	existingFiles := preCheckExistingFiles(tilesFolderPath, width)
+
+	processed := f.restored * region.Height
+	total := region.tiles()
+	if sample != nil {
+		total = len(sample.picked)
+	}
+
+	// Columns a previous run already finished, the producer skips them entirely. Relative to the region
+	skip := make([]bool, region.Width)
+	for x := range region.Width {
+		skip[x] = f.skipped(x)
+	}
+	wantEmpty := f.wantEmpty
 
 	var wg sync.WaitGroup
 
 	for range numWorkers {
 		wg.Add(1)
-		go worker(jobs, results, &wg, processor, tilesFolderPath, opts)
+		go worker(jobs, results, &wg, folderNumber, ops, grids, report.status, f.cache, src.Path)
 	}
 
 	go func() {
 		defer close(jobs)
-		for x := range width {
-			for y := range height {
-				filepath := fmt.Sprintf("%s/%d/%d.png", tilesFolderPath, x, y)
+
+		emptyTile := func(x, y int) {
+			if wantEmpty {
+				jobs <- Job{x: x, y: y, empty: true}
+			} else {
+				results <- Result{x: x, y: y}
+			}
+		}
+
+		if sample != nil {
+			if src.Archive != nil {
+				scanArchive(src.Archive, sample.coords(), numWorkers, jobs)
+				return
+			}
+			for _, p := range sample.picked {
+				jobs <- Job{x: p[0], y: p[1]}
+			}
+			return
+		}
+
+		if src.Archive != nil {
+			feedArchive(src.Archive, region, numWorkers, skip, jobs, emptyTile)
+			return
+		}
+
+		existingFiles := preCheckExistingFiles(src.Path, region)
+		for x := region.X; x < region.X+region.Width; x++ {
+			if skip[x-region.X] {
+				continue
+			}
+			for y := region.Y; y < region.Y+region.Height; y++ {
+				filepath := fmt.Sprintf("%s/%d/%d.png", src.Path, x, y)
 				if existingFiles[filepath] {
 					jobs <- Job{x: x, y: y}
 				} else {
-					results <- Result{x: x, y: y, rgb: RGB{0, 0, 0}}
+					emptyTile(x, y)
 				}
 			}
 		}
 	}()
 
-	pixelData := make([][]RGB, width)
-	allPixels := make([]RGB, width*height)
-	for i := range pixelData {
-		pixelData[i] = allPixels[i*height : (i+1)*height]
-	}
-
 	go func() {
 		wg.Wait()
 		close(results)
 	}()
 
-	processed := 0
-	total := width * height
-
-	suffix := ""
-	if opts.IncludeTransparency {
-		suffix += "-t"
+	names := make([]string, len(ops))
+	for i, op := range ops {
+		names[i] = op.Name()
 	}
-	if opts.IncludeBoring {
-		suffix += "-b"
+
+	fmt.Printf("Processing %d pixels in %s with %d workers doing %s...\n", total, src, numWorkers, strings.Join(names, ", "))
+
+	var tick <-chan time.Time
+	if cp != nil {
+		ticker := time.NewTicker(checkpointEvery)
+		defer ticker.Stop()
+		tick = ticker.C
 	}
 
-	fmt.Printf("Processing %d pixels in %s with %d workers doing %s%s...\n", total, tilesFolderPath, numWorkers, processor, suffix)
+	// Ctrl+C saves whatever's finished before quitting
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	// Workers write straight into the grids, results are only here to count progress
+	for running := true; running; {
+		var r Result
+		select {
+		case r, running = <-results:
+			if !running {
+				continue
+			}
+
+		case <-tick:
+			saveCheckpoint(cp)
+			continue
+
+		case <-interrupt:
+			if cp != nil {
+				saveCheckpoint(cp)
+			}
+			fmt.Println("Interrupted!")
+			os.Exit(130)
+		}
 
-	for result := range results {
-		pixelData[result.x][result.y] = result.rgb
 		processed++
+		report.add(r)
+		if cp != nil {
+			cp.tileDone(r.x - region.X)
+		}
 
 		if processed%20_000 == 0 {
 			elapsed := time.Since(startTime)
@@ -314,323 +594,136 @@ func runProcess(folderNumber int, processor string, width, height, numWorkers in
 	processingTime := time.Since(startTime)
 	fmt.Printf("Processing complete! Took: %v\n", processingTime.Round(time.Millisecond))
 
-	fmt.Println("Creating image...")
-	imageStartTime := time.Now()
-
-	img := image.NewRGBA(image.Rect(0, 0, width, height))
-
-	// I think doing it without img.Set is faster. Also I fucking love go
-	pixels := img.Pix
-	stride := img.Stride
-	for y := range height {
-		off := y * stride
-		for x := range width {
-			rgb := pixelData[x][y]
-			pixels[off+0] = rgb.R
-			pixels[off+1] = rgb.G
-			pixels[off+2] = rgb.B
-			pixels[off+3] = 255
-			off += 4
-		}
-	}
-
-	imageCreationTime := time.Since(imageStartTime)
-	fmt.Printf("Image creation took: %v\n", imageCreationTime.Round(time.Millisecond))
+	f.finish()
 
-	outputFolder := fmt.Sprintf("%s/data", wplacePath)
-	if !exists(outputFolder) {
-		fmt.Printf("Creating output folder %s...\n", outputFolder)
-		os.Mkdir(outputFolder, os.ModePerm)
-	}
-	outputPath := fmt.Sprintf("%s/%d-%s%s.png", outputFolder, folderNumber, processor, suffix)
-
-	fmt.Fprintf(os.Stderr, "Saving image %s to disk...", outputPath)
-	saveStartTime := time.Now()
-
-	file, err := os.Create(outputPath)
-	if err != nil {
-		panic(err)
-	}
-	defer file.Close()
-
-	encoder := png.Encoder{CompressionLevel: png.BestCompression}
-
-	if err := encoder.Encode(file, img); err != nil {
-		panic(err)
-	}
-
-	saveTime := time.Since(saveStartTime)
 	totalTime := time.Since(startTime)
-
-	fmt.Printf("Image saved successfully!\n")
-	fmt.Printf("Save took: %v\n", saveTime.Round(time.Millisecond))
 	fmt.Printf("Total time: %v\n", totalTime.Round(time.Millisecond))
 	fmt.Printf("Average: %.2f pixels/second\n", float64(total)/totalTime.Seconds())
-
-}
-
-func exists(basepath string) bool {
-	_, err := os.Stat(basepath)
-	return !errors.Is(err, os.ErrNotExist)
 }
 
-func worker(jobs <-chan Job, results chan<- Result, wg *sync.WaitGroup, processor string, basepath string, opts ProcessOpts) {
-	defer wg.Done()
-	for job := range jobs {
-		filepath := fmt.Sprintf("%s/%d/%d.png", basepath, job.x, job.y)
-
-		rgb, err := processPath(processor, filepath, opts)
-		if err != nil {
-			rgb = RGB{R: 0, G: 0, B: 0}
+// Empty tiles are counted straight away, then every tile is read out of the archive in its own order,
+// which is the only fast way to get at a solid 7z
+func feedArchive(archive *tilearchive.Archive, region Region, parallel int, skip []bool, jobs chan<- Job, emptyTile func(x, y int)) {
+	var coords []tilearchive.Coord
+	for x := region.X; x < region.X+region.Width; x++ {
+		if skip[x-region.X] {
+			continue
+		}
+		for y := region.Y; y < region.Y+region.Height; y++ {
+			if archive.Has(x, y) {
+				coords = append(coords, tilearchive.Coord{X: x, Y: y})
+			} else {
+				emptyTile(x, y)
+			}
 		}
-
-		results <- Result{x: job.x, y: job.y, rgb: rgb}
 	}
-}
-
-func processPath(function string, filepath string, opts ProcessOpts) (RGB, error) {
-	var result RGB
-	var err error
 
-	switch function {
-	case "average":
-		result, err = averageImageFromFile(filepath, opts)
-
-	case "count":
-		result, err = countImageFromFile(filepath)
-
-	case "mode":
-		result, err = modeImageFromFile(filepath, opts)
-
-	default:
-		fmt.Fprintf(os.Stderr, "Unknown function: %s\n", function)
-		os.Exit(1)
-	}
+	scanArchive(archive, coords, parallel, jobs)
+}
 
+func scanArchive(archive *tilearchive.Archive, coords []tilearchive.Coord, parallel int, jobs chan<- Job) {
+	err := archive.ScanTiles(coords, parallel, func(e tilearchive.Entry, data []byte, err error) error {
+		jobs <- Job{x: e.X, y: e.Y, data: data, err: err}
+		return nil
+	})
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Processing error: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error reading archive: %v\n", err)
 		os.Exit(1)
 	}
-
-	return result, nil
-
 }
 
-func imageFromFile(filepath string) (*image.RGBA, error) {
-	file, err := os.Open(filepath)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-	img, err := png.Decode(file)
-	if err != nil {
-		return nil, err
-	}
-
-	bounds := img.Bounds()
-	switch typedImg := img.(type) {
-	case *image.RGBA:
-		return typedImg, nil
-
-	default:
-		rgba := image.NewRGBA(bounds)
-		draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
-		return rgba, nil
+// A failed checkpoint isn't worth stopping a run over, the results are all still in memory
+func saveCheckpoint(cp *checkpoint) {
+	if err := cp.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving checkpoint: %v\n", err)
 	}
 }
 
-func averageImageFromFile(filepath string, opts ProcessOpts) (RGB, error) {
-	img, err := imageFromFile(filepath)
-	if err != nil {
-		return RGB{0, 0, 0}, nil
-	}
-
-	bounds := img.Bounds()
-	width, height := bounds.Dx(), bounds.Dy()
-	return averageRGBA(img.Pix, width, height, opts)
+func exists(basepath string) bool {
+	_, err := os.Stat(basepath)
+	return !errors.Is(err, os.ErrNotExist)
 }
 
-func averageRGBA(pixels []uint8, width, height int, opts ProcessOpts) (RGB, error) {
-	if width <= 0 || height <= 0 || len(pixels) < width*height*4 {
-		return RGB{}, fmt.Errorf("invalid input")
-	}
-
-	var r, g, b, count uint64
-	for i := 0; i < len(pixels); i += 4 {
-		// Since my images never contain semi-transparent pixels (alpha is always 0 or 255)
-		// I decided for simplicity and speed (up to 13% from small test?), I can just not handle partial transparency
-		// This makes the function less general; images with semi-transparent pixels will not work as expected
-		alpha := pixels[i+3]
-		if alpha == 0 && !opts.IncludeTransparency {
-			continue
-		}
-
-		r += uint64(pixels[i])
-		g += uint64(pixels[i+1])
-		b += uint64(pixels[i+2])
-		count++
-	}
-
-	if count == 0 {
-		return RGB{0, 0, 0}, nil
+func worker(jobs <-chan Job, results chan<- Result, wg *sync.WaitGroup, folderNumber int, ops []Operation, grids []*Grid, status *Grid, cache *tileCache, basepath string) {
+	defer wg.Done()
+	for job := range jobs {
+		res := processTile(job, folderNumber, ops, grids, cache, basepath)
+		status.AtTile(job.x, job.y)[0] = byte(res.problem)
+		results <- res
 	}
-
-	return RGB{
-		R: uint8(r / count),
-		G: uint8(g / count),
-		B: uint8(b / count),
-	}, nil
 }
 
-func countImageFromFile(filepath string) (RGB, error) {
-
-	img, err := imageFromFile(filepath)
-	if err != nil {
-		return RGB{0, 0, 0}, nil
+// Whether processTile has to hash every tile, see TileHasher
+func needsHash(ops []Operation) bool {
+	for _, op := range ops {
+		if h, ok := op.(TileHasher); ok && h.NeedsHash() {
+			return true
+		}
 	}
-
-	bounds := img.Bounds()
-	width, height := bounds.Dx(), bounds.Dy()
-
-	return countRGBA(img.Pix, width, height)
+	return false
 }
 
-func countRGBA(pixels []uint8, width, height int) (RGB, error) {
-	var totalCount float64
-	pixelCount := width * height
-
-	for i := 0; i < len(pixels); i += 4 {
-		if pixels[i+3] > 0 {
-			totalCount += 1.0
+// Decode once, then hand the same pixels to every operation. With a cache, tiles that haven't changed
+// since the folder before aren't decoded at all.
+// Bad tiles are left zeroed, same as an empty tile, and reported instead of stopping the run
+func processTile(job Job, folderNumber int, ops []Operation, grids []*Grid, cache *tileCache, basepath string) Result {
+	res := Result{x: job.x, y: job.y}
+	fail := func(p tileProblem, err error) Result {
+		for i := range ops {
+			clear(grids[i].AtTile(job.x, job.y))
 		}
+		if cache != nil {
+			cache.forget(job.x, job.y)
+		}
+		res.problem, res.detail = p, err.Error()
+		return res
 	}
 
-	const (
-		fracAtHalf = 0.01 // want value=0.5 at this total/pixel fraction
-		hueExp     = 0.8  // >1 = linger near red longer
-		lightExp   = 1.6  // >1 = darker early
-		lightMax   = 0.9  // brightness ceiling
-	)
-
-	var norm float64
-	if pixelCount > 0 {
-		norm = math.Log1p(totalCount) / math.Log1p(float64(pixelCount))
-	}
-	if norm < 0 {
-		norm = 0
-	} else if norm > 1 {
-		norm = 1
-	}
-
-	f := fracAtHalf
-	nf := math.Log1p(f*float64(pixelCount)) / math.Log1p(float64(pixelCount))
-	valueExp := math.Log(0.5) / math.Log(nf)
-
-	value := math.Pow(norm, valueExp)
-	hue := math.Pow(value, hueExp)
-	light := math.Pow(value, lightExp) * lightMax
-
-	return hslToRgb(HSL{H: hue, S: 1, L: light}), nil
-}
-
-// Adapted from stackoverflow.com/a/9493060/119527
-func hslToRgb(hsl HSL) RGB {
-	var r, g, b uint8
-	var h, s, l float64 = hsl.H, hsl.S, hsl.L
-	var q, p float64
-
-	if s == 0 {
-		gray := uint8(math.Round(l * 255))
-		r, g, b = gray, gray, gray
-	} else {
-		if l < 0.5 {
-			q = l * (1 + s)
-		} else {
-			q = l + s - l*s
+	if job.empty {
+		tile := &Tile{Folder: folderNumber, X: job.x, Y: job.y}
+		for i, op := range ops {
+			if e, ok := op.(EmptyTileProcessor); ok {
+				if err := e.ProcessEmpty(tile, grids[i].AtTile(job.x, job.y)); err != nil {
+					return fail(tileFailed, fmt.Errorf("%s: %w", op.Name(), err))
+				}
+			}
 		}
-		p = 2*l - q
-		r = uint8(math.Round(float64(hueToRgb(p, q, h+1.0/3)) * 255))
-		g = uint8(math.Round(float64(hueToRgb(p, q, h)) * 255))
-		b = uint8(math.Round(float64(hueToRgb(p, q, h-1.0/3)) * 255))
+		return res
 	}
 
-	return RGB{R: r, G: g, B: b}
-}
-
-func hueToRgb(p, q, t float64) float64 {
-	if t < 0 {
-		t += 1
-	}
-	if t > 1 {
-		t -= 1
-	}
-	if t < 1.0/6 {
-		return p + (q-p)*6*t
+	// Hashing needs the whole file, otherwise it's decoded straight off the disk
+	hashed := cache != nil || needsHash(ops)
+	data, err := job.data, job.err
+	if err == nil && data == nil && hashed {
+		data, err = os.ReadFile(fmt.Sprintf("%s/%d/%d.png", basepath, job.x, job.y))
 	}
-	if t < 1.0/2 {
-		return q
+	var hash uint64
+	if err == nil && hashed {
+		hash = hashTile(data)
 	}
-	if t < 2.0/3 {
-		return p + (q-p)*(2.0/3-t)*6
+	if err == nil && cache != nil && cache.reuse(&Tile{Folder: folderNumber, X: job.x, Y: job.y, Hash: hash}, ops, grids) {
+		return res
 	}
-	return p
-}
 
-func modeImageFromFile(filepath string, opts ProcessOpts) (RGB, error) {
-	img, err := imageFromFile(filepath)
+	var tile *Tile
+	if err == nil && data != nil {
+		tile, err = decodeTile(bytes.NewReader(data))
+	} else if err == nil {
+		tile, err = tileFromFile(fmt.Sprintf("%s/%d/%d.png", basepath, job.x, job.y))
+	}
 	if err != nil {
-		return RGB{0, 0, 0}, nil
+		return fail(classifyReadError(err), err)
 	}
 
-	bounds := img.Bounds()
-	width, height := bounds.Dx(), bounds.Dy()
-
-	return modeRGBA(img.Pix, width, height, opts)
-}
-
-func modeRGBA(pixels []uint8, width, height int, opts ProcessOpts) (RGB, error) {
-	counts := make(map[uint32]int, 64)
-	pixelCount := width * height
-
-	for pixel := range pixelCount {
-		idx := pixel * 4
-		r := pixels[idx]
-		g := pixels[idx+1]
-		b := pixels[idx+2]
-		a := pixels[idx+3]
-
-		if a == 0 {
-			continue
-		}
-
-		packed := uint32(r)<<16 | uint32(g)<<8 | uint32(b)
-
-		if !opts.IncludeBoring {
-			if r == 0 && g == 0 && b == 0 {
-				continue
-			}
-			if r == 255 && g == 255 && b == 255 {
-				continue
-			}
-		}
-
-		counts[packed]++
+	if tile.Width != tileSize || tile.Height != tileSize {
+		return fail(tileWrongSize, fmt.Errorf("tile is %dx%d, expected %dx%d", tile.Width, tile.Height, tileSize, tileSize))
 	}
 
-	var maxCount int
-	var mostFreqColour RGB
-
-	for packed, count := range counts {
-		if count > maxCount {
-			maxCount = count
-			colour := RGB{R: byte(packed >> 16), G: byte(packed >> 8), B: byte(packed)}
-			mostFreqColour = colour
+	tile.Folder, tile.X, tile.Y, tile.Hash = folderNumber, job.x, job.y, hash
+	for i, op := range ops {
+		if err := op.Process(tile, grids[i].AtTile(job.x, job.y)); err != nil {
+			return fail(tileFailed, fmt.Errorf("%s: %w", op.Name(), err))
 		}
 	}
-
-	if maxCount == 0 {
-		return RGB{0, 0, 0}, nil
-	}
-
-	return mostFreqColour, nil
+	return res
 }
@@ -0,0 +1,119 @@
+package main
+
+import "strings"
+
+// Black and white are everywhere and tell you nothing, so mode skips them unless asked not to
+const defaultBoring = "000000+ffffff"
+
+func init() {
+	registerOperation(OperationSpec{
+		Key:    "m",
+		Flags:  "b",
+		Params: []string{"x"},
+		Usage:  "mode (b=include boring, x=boring colours as hex joined by +, default " + defaultBoring + ")",
+		New:    newModeOperation,
+	})
+}
+
+type modeOperation struct {
+	pureOperation
+	name   string
+	boring map[uint32]bool
+}
+
+func newModeOperation(o Options) (Operation, error) {
+	op := modeOperation{name: "mode" + o.Suffix(), boring: make(map[uint32]bool)}
+
+	if x, ok := o.Params["x"]; ok {
+		op.name += "-x" + strings.ReplaceAll(strings.ToLower(x), "+", "-")
+	}
+
+	if o.Has('b') {
+		return op, nil
+	}
+
+	for _, h := range strings.Split(o.String("x", defaultBoring), "+") {
+		if h == "" {
+			continue
+		}
+		rgb, err := parseHex(h)
+		if err != nil {
+			return nil, err
+		}
+		op.boring[rgb.packed()] = true
+	}
+
+	return op, nil
+}
+
+func (op modeOperation) Name() string {
+	return op.name
+}
+
+func (modeOperation) RecordSize() int {
+	return 3
+}
+
+func (op modeOperation) Process(tile *Tile, rec []byte) error {
+	if tile.paletted() {
+		modePaletted(tile, op.boring).put(rec)
+		return nil
+	}
+
+	rgb, err := modeRGBA(tile.Pix, tile.Width, tile.Height, op.boring)
+	if err != nil {
+		return err
+	}
+	rgb.put(rec)
+	return nil
+}
+
+func (op modeOperation) Encode(out *Output, grid *Grid) error {
+	return out.SaveRGB(op.name, grid.Width, grid.Height, func(x, y int) RGB {
+		return rgbFromRecord(grid.At(x, y))
+	})
+}
+
+func modeRGBA(pixels []uint8, width, height int, boring map[uint32]bool) (RGB, error) {
+	counts := make(map[uint32]int, 64)
+	pixelCount := width * height
+
+	for pixel := range pixelCount {
+		idx := pixel * 4
+		r := pixels[idx]
+		g := pixels[idx+1]
+		b := pixels[idx+2]
+		a := pixels[idx+3]
+
+		if a == 0 {
+			continue
+		}
+
+		packed := uint32(r)<<16 | uint32(g)<<8 | uint32(b)
+
+		counts[packed]++
+	}
+
+	return modeOf(counts, boring), nil
+}
+
+func modePaletted(tile *Tile, boring map[uint32]bool) RGB {
+	counts := make(map[uint32]int, 64)
+	paletteColourCounts(tile, counts)
+	return modeOf(counts, boring)
+}
+
+func modeOf(counts map[uint32]int, boring map[uint32]bool) RGB {
+	// Cheaper to drop them once here than to check every pixel
+	for packed := range boring {
+		delete(counts, packed)
+	}
+
+	// Ties go by palette order, otherwise map order would pick a different one every run
+	best, ok := mostCommon(counts)
+	if !ok {
+		return RGB{0, 0, 0}
+	}
+
+	return unpackRGB(best.packed)
+}
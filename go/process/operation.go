@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Every wplace tile is 1000x1000
+const tileSize = 1000
+
+// An Operation is one metric worked out for every tile in a single pass.
+//
+// Process gets every decoded tile and fills in a fixed size record for it, then once the whole
+// folder is done Encode turns the grid of records into output files. Empty tiles never reach
+// Process, their record is left zeroed, so a zero record should mean "nothing here".
+//
+// Process is called from many workers at once, each with its own tile and record, so it must not
+// touch anything shared. Anything world-wide (totals, rankings, etc.) belongs in Encode.
+type Operation interface {
+	Name() string
+	RecordSize() int
+	Process(tile *Tile, rec []byte) error
+	Encode(out *Output, grid *Grid) error
+}
+
+// Operations that compare against other snapshots need to know about tiles that are empty in this
+// one too, a tile that got wiped is still a change. ProcessEmpty gets those with no pixels at all
+type EmptyTileProcessor interface {
+	ProcessEmpty(tile *Tile, rec []byte) error
+}
+
+// Called once before a folder's tiles go out, so an operation can check whatever else it needs
+// (other snapshots, state from earlier folders, etc.) is there instead of failing on every single tile
+type Preparer interface {
+	Prepare(out *Output) error
+}
+
+// Operations that read another folder's tiles while processing one say which, so the -e pipeline
+// keeps that folder on disk until it's done with it
+type FolderReader interface {
+	ReadsFolder(folder int) int
+}
+
+// Operations that only compare against the folder before when they can (see previousFolder) say
+// whether they do for the folder they've just prepared, so -u workers can be told to do the same
+type PreviousComparer interface {
+	ComparesPrevious() bool
+}
+
+// Operations whose memory grows faster than the region's tile count say whether a region is too big,
+// before anything is read instead of once the allocation fails
+type RegionChecker interface {
+	CheckRegion(region Region) error
+}
+
+// Operations that tell tiles apart by their file instead of their pixels get its hashTile in
+// Tile.Hash, which costs reading the whole file first. It's 0 for everyone else
+type TileHasher interface {
+	NeedsHash() bool
+}
+
+// A decoded tile, either Pix (RGBA, 4 bytes per pixel) or Index + Palette (one palette index per
+// pixel) is set, never both. Neither has padding between rows. Paletted tiles are the usual case, see
+// paletted.go. X/Y are always the real tile numbers, even when only a region is being processed
+type Tile struct {
+	Folder        int
+	X, Y          int
+	Width, Height int
+	Hash          uint64 // see TileHasher
+	Pix           []uint8
+
+	Index   []uint8
+	Palette *tilePalette
+}
+
+// One record per tile in the region, x-major (x*Height+y) so a column of tiles is contiguous.
+// At takes x/y relative to the region, add X/Y to get back to real tile numbers
+type Grid struct {
+	Region
+	RecordSize int
+	Data       []byte
+}
+
+func newGrid(region Region, recordSize int) *Grid {
+	return &Grid{Region: region, RecordSize: recordSize, Data: make([]byte, region.tiles()*recordSize)}
+}
+
+func (g *Grid) At(x, y int) []byte {
+	off := (x*g.Height + y) * g.RecordSize
+	return g.Data[off : off+g.RecordSize : off+g.RecordSize]
+}
+
+// At with real tile numbers, for anything going by a Tile or Job
+func (g *Grid) AtTile(x, y int) []byte {
+	return g.At(x-g.X, y-g.Y)
+}
+
+// Where an operation writes its files, namely <wplace>/data/<folder>-<name>.<ext>
+type Output struct {
+	Folder int
+	Dir    string
+
+	// If set, bad tiles are painted this colour on every map instead of whatever their record says
+	Marker *RGB
+	report *tileReport
+
+	// Only on a -u worker, where Prepare has to go by the coordinator instead of any state lying
+	// around: whether to compare against the folder before, see unit
+	remote, continuing bool
+}
+
+func (o *Output) Path(name, ext string) string {
+	return fmt.Sprintf("%s/%d-%s.%s", o.Dir, o.Folder, name, ext)
+}
+
+func (o *Output) SavePNG(name string, img image.Image) error {
+	outputPath := o.Path(name, "png")
+
+	fmt.Fprintf(os.Stderr, "Saving image %s to disk...", outputPath)
+	saveStartTime := time.Now()
+
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+
+	if err := encoder.Encode(file, img); err != nil {
+		return err
+	}
+
+	fmt.Printf("Image saved successfully!\n")
+	fmt.Printf("Save took: %v\n", time.Since(saveStartTime).Round(time.Millisecond))
+	return nil
+}
+
+// Whether tile x,y should be painted in the marker colour instead
+func (o *Output) marked(x, y int) bool {
+	return o.Marker != nil && o.report != nil && o.report.problem(x, y) != tileOK
+}
+
+// The world map every operation ends up making, one pixel per tile
+func (o *Output) SaveRGB(name string, width, height int, colourAt func(x, y int) RGB) error {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	// I think doing it without img.Set is faster. Also I fucking love go
+	pixels := img.Pix
+	stride := img.Stride
+	for y := range height {
+		off := y * stride
+		for x := range width {
+			rgb := colourAt(x, y)
+			if o.marked(x, y) {
+				rgb = *o.Marker
+			}
+			pixels[off+0] = rgb.R
+			pixels[off+1] = rgb.G
+			pixels[off+2] = rgb.B
+			pixels[off+3] = 255
+			off += 4
+		}
+	}
+
+	return o.SavePNG(name, img)
+}
+
+// What -o gets parsed against. Every operation registers one of these from an init in its own file
+type OperationSpec struct {
+	Key    string   // the -o token, e.g. "m"
+	Flags  string   // single letter modifiers it accepts, e.g. "b" for "mb"
+	Params []string // names it accepts after a colon, e.g. "f" for "s:f=csv"
+	Usage  string
+	New    func(o Options) (Operation, error)
+}
+
+// Options for one -o token, written as key[flags][:param=value...], e.g. "mb" or "s:f=jsonl"
+type Options struct {
+	Flags  string // only flags from the spec, in spec order
+	Params map[string]string
+}
+
+func (o Options) Has(flag byte) bool {
+	return strings.IndexByte(o.Flags, flag) >= 0
+}
+
+// Flags as they've always been in file names, e.g. "-t-b"
+func (o Options) Suffix() string {
+	suffix := ""
+	for i := range len(o.Flags) {
+		suffix += "-" + o.Flags[i:i+1]
+	}
+	return suffix
+}
+
+func (o Options) String(name, def string) string {
+	if v, ok := o.Params[name]; ok {
+		return v
+	}
+	return def
+}
+
+func (o Options) Int(name string, def int) (int, error) {
+	v, ok := o.Params[name]
+	if !ok {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("param %s: %w", name, err)
+	}
+	return i, nil
+}
+
+func (o Options) Float(name string, def float64) (float64, error) {
+	v, ok := o.Params[name]
+	if !ok {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("param %s: %w", name, err)
+	}
+	return f, nil
+}
+
+var operationSpecs = map[string]OperationSpec{}
+
+func registerOperation(spec OperationSpec) {
+	if _, ok := operationSpecs[spec.Key]; ok {
+		panic("operation registered twice: " + spec.Key)
+	}
+	operationSpecs[spec.Key] = spec
+}
+
+func operationsUsage() string {
+	keys := make([]string, 0, len(operationSpecs))
+	for k := range operationSpecs {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%s", k, operationSpecs[k].Usage))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func checkRegion(ops []Operation, region Region) error {
+	for _, op := range ops {
+		if c, ok := op.(RegionChecker); ok {
+			if err := c.CheckRegion(region); err != nil {
+				return fmt.Errorf("%s: %w", op.Name(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// Every operation is run in the same pass over the tiles, so each tile is only decoded once
+func parseOperations(operationsString string) ([]Operation, error) {
+	tokens := strings.FieldsFunc(operationsString, func(r rune) bool { return r == ',' || r == ' ' })
+
+	ops := make([]Operation, 0, len(tokens))
+	seen := make(map[string]bool, len(tokens))
+	for _, t := range tokens {
+		op, err := parseOperation(t)
+		if err != nil {
+			return nil, err
+		}
+		// Asking for the same thing twice would just write the same files twice
+		// The stats formats all share a name but write different files, so the token is what counts
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		ops = append(ops, op)
+	}
+
+	if len(ops) == 0 {
+		return nil, errors.New("no operations specified")
+	}
+	return ops, nil
+}
+
+func parseOperation(token string) (Operation, error) {
+	parts := strings.Split(token, ":")
+	head := parts[0]
+
+	// Longest key wins, whatever is left over has to be flags that key knows about
+	var spec OperationSpec
+	var flags string
+	found := false
+	for i := len(head); i > 0 && !found; i-- {
+		s, ok := operationSpecs[head[:i]]
+		if !ok {
+			continue
+		}
+		rest := head[i:]
+		if strings.Trim(rest, s.Flags) != "" {
+			continue
+		}
+		spec, flags, found = s, rest, true
+	}
+	if !found {
+		return nil, fmt.Errorf("unknown operation %q", token)
+	}
+
+	opts := Options{Params: make(map[string]string)}
+	for i := range len(spec.Flags) {
+		if strings.IndexByte(flags, spec.Flags[i]) >= 0 {
+			opts.Flags += spec.Flags[i : i+1]
+		}
+	}
+
+	for _, p := range parts[1:] {
+		name, value, ok := strings.Cut(p, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("operation %q: param %q should look like name=value", token, p)
+		}
+		if !slices.Contains(spec.Params, name) {
+			return nil, fmt.Errorf("operation %q: unknown param %q", token, name)
+		}
+		opts.Params[name] = value
+	}
+
+	op, err := spec.New(opts)
+	if err != nil {
+		return nil, fmt.Errorf("operation %q: %w", token, err)
+	}
+	return op, nil
+}
+
+// One map per value in a record, each with its own ramp and legend
+type tileMap struct {
+	name  string // the whole file name, usually op.Name() + "-something"
+	ramp  *colourRamp
+	value func(rec []byte) uint32
+	title string
+	label func(float64) string
+}
+
+func saveTileMaps(out *Output, grid *Grid, maps []tileMap) error {
+	values := make([]uint32, grid.Width*grid.Height)
+	for _, m := range maps {
+		for x := range grid.Width {
+			for y := range grid.Height {
+				values[x*grid.Height+y] = m.value(grid.At(x, y))
+			}
+		}
+		m.ramp.fit(values)
+
+		err := out.SaveRGB(m.name, grid.Width, grid.Height, func(x, y int) RGB {
+			return m.ramp.colour(float64(values[x*grid.Height+y]))
+		})
+		if err != nil {
+			return err
+		}
+		if err := out.SavePNG(m.name+"-legend", m.ramp.legend(m.title, m.label)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// One row per tile, x,y (real tile numbers) then whatever row returns for it. An empty string leaves
+// the tile out
+func saveTileCSV(outputPath, header string, grid *Grid, row func(x, y int, rec []byte) string) error {
+	fmt.Fprintf(os.Stderr, "Saving %s to disk...", outputPath)
+
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriterSize(file, 1<<20)
+	w.WriteString(header + "\n")
+
+	var line []byte
+	for x := range grid.Width {
+		for y := range grid.Height {
+			rest := row(grid.X+x, grid.Y+y, grid.At(x, y))
+			if rest == "" {
+				continue
+			}
+
+			line = line[:0]
+			line = strconv.AppendInt(line, int64(grid.X+x), 10)
+			line = append(line, ',')
+			line = strconv.AppendInt(line, int64(grid.Y+y), 10)
+			line = append(line, ',')
+			line = append(line, rest...)
+			line = append(line, '\n')
+
+			if _, err := w.Write(line); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Data saved successfully!\n")
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseOperation(t *testing.T) {
+	tests := []struct {
+		token   string
+		name    string // "" = should fail
+		wantErr string
+	}{
+		{token: "c", name: "count"},
+		{token: "c:scale=linear", name: "count-linear"},
+		{token: "c:scale=linear:cmap=viridis", name: "count-linear-viridis"},
+		{token: "m", name: "mode"},
+		{token: "mb", name: "mode-b"},
+		{token: "m:x=FF0000+00ff00", name: "mode-xff0000-00ff00"},
+		{token: "a", name: "average"},
+		{token: "at", name: "average-t"},
+		{token: "k:n=5", name: "top5"},
+		{token: "d:from=3", name: "diff-from3"},
+
+		{token: "z", wantErr: "unknown operation"},
+		{token: "mq", wantErr: "unknown operation"},
+		{token: "c:bogus=1", wantErr: "unknown param"},
+		{token: "c:scale", wantErr: "name=value"},
+		{token: "c:=linear", wantErr: "name=value"},
+		{token: "c:scale=cubic", wantErr: "unknown scale"},
+		{token: "c:min=5:max=5", wantErr: "has to be above"},
+		{token: "k:n=0", wantErr: "has to be between"},
+		{token: "k:n=x", wantErr: "param n"},
+		{token: "m:x=12345", wantErr: "6 hex digits"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			op, err := parseOperation(tt.token)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := op.Name(); got != tt.name {
+				t.Errorf("name = %q, want %q", got, tt.name)
+			}
+		})
+	}
+}
+
+func TestParseOperations(t *testing.T) {
+	tests := []struct {
+		in    string
+		names []string
+	}{
+		{"c m", []string{"count", "mode"}},
+		{"c,m", []string{"count", "mode"}},
+		{"c  c,m c", []string{"count", "mode"}},
+		{"s:f=csv s:f=bin", []string{"stats", "stats"}},
+	}
+	for _, tt := range tests {
+		ops, err := parseOperations(tt.in)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.in, err)
+		}
+		var names []string
+		for _, op := range ops {
+			names = append(names, op.Name())
+		}
+		if strings.Join(names, " ") != strings.Join(tt.names, " ") {
+			t.Errorf("%q = %v, want %v", tt.in, names, tt.names)
+		}
+	}
+
+	if _, err := parseOperations(" , "); err == nil {
+		t.Error("no operations should be an error")
+	}
+}
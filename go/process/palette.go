@@ -0,0 +1,172 @@
+package main
+
+import "slices"
+
+// The wplace palette in the order the site lists it, transparent (index 0) left out.
+// Anything that has to pick between colours fairly (ties, rankings) goes by this order.
+var wplacePalette = []struct {
+	Name    string
+	RGB     RGB
+	Premium bool
+}{
+	{"Black", RGB{0x00, 0x00, 0x00}, false},
+	{"Dark Gray", RGB{0x3c, 0x3c, 0x3c}, false},
+	{"Gray", RGB{0x78, 0x78, 0x78}, false},
+	{"Light Gray", RGB{0xd2, 0xd2, 0xd2}, false},
+	{"White", RGB{0xff, 0xff, 0xff}, false},
+	{"Deep Red", RGB{0x60, 0x00, 0x18}, false},
+	{"Red", RGB{0xed, 0x1c, 0x24}, false},
+	{"Orange", RGB{0xff, 0x7f, 0x27}, false},
+	{"Gold", RGB{0xf6, 0xaa, 0x09}, false},
+	{"Yellow", RGB{0xf9, 0xdd, 0x3b}, false},
+	{"Light Yellow", RGB{0xff, 0xfa, 0xbc}, false},
+	{"Dark Green", RGB{0x0e, 0xb9, 0x68}, false},
+	{"Green", RGB{0x13, 0xe6, 0x7b}, false},
+	{"Light Green", RGB{0x87, 0xff, 0x5e}, false},
+	{"Dark Teal", RGB{0x0c, 0x81, 0x6e}, false},
+	{"Teal", RGB{0x10, 0xae, 0xa6}, false},
+	{"Light Teal", RGB{0x13, 0xe1, 0xbe}, false},
+	{"Dark Blue", RGB{0x28, 0x50, 0x9e}, false},
+	{"Blue", RGB{0x40, 0x93, 0xe4}, false},
+	{"Cyan", RGB{0x60, 0xf7, 0xf2}, false},
+	{"Indigo", RGB{0x6b, 0x50, 0xf6}, false},
+	{"Light Indigo", RGB{0x99, 0xb1, 0xfb}, false},
+	{"Dark Purple", RGB{0x78, 0x0c, 0x99}, false},
+	{"Purple", RGB{0xaa, 0x38, 0xb9}, false},
+	{"Light Purple", RGB{0xe0, 0x9f, 0xf9}, false},
+	{"Dark Pink", RGB{0xcb, 0x00, 0x7a}, false},
+	{"Pink", RGB{0xec, 0x1f, 0x80}, false},
+	{"Light Pink", RGB{0xf3, 0x8d, 0xa9}, false},
+	{"Dark Brown", RGB{0x68, 0x46, 0x34}, false},
+	{"Brown", RGB{0x95, 0x68, 0x2a}, false},
+	{"Beige", RGB{0xf8, 0xb2, 0x77}, false},
+
+	// Premium, these have to be bought on the site. Everything above is free
+	{"Medium Gray", RGB{0xaa, 0xaa, 0xaa}, true},
+	{"Dark Red", RGB{0xa5, 0x0e, 0x1e}, true},
+	{"Light Red", RGB{0xfa, 0x80, 0x72}, true},
+	{"Dark Orange", RGB{0xe4, 0x5c, 0x1a}, true},
+	{"Light Tan", RGB{0xd6, 0xb5, 0x94}, true},
+	{"Dark Goldenrod", RGB{0x9c, 0x84, 0x31}, true},
+	{"Goldenrod", RGB{0xc5, 0xad, 0x31}, true},
+	{"Light Goldenrod", RGB{0xe8, 0xd4, 0x5f}, true},
+	{"Dark Olive", RGB{0x4a, 0x6b, 0x3a}, true},
+	{"Olive", RGB{0x5a, 0x94, 0x4a}, true},
+	{"Light Olive", RGB{0x84, 0xc5, 0x73}, true},
+	{"Dark Cyan", RGB{0x0f, 0x79, 0x9f}, true},
+	{"Light Cyan", RGB{0xbb, 0xfa, 0xf2}, true},
+	{"Light Blue", RGB{0x7d, 0xc7, 0xff}, true},
+	{"Dark Indigo", RGB{0x4d, 0x31, 0xb8}, true},
+	{"Dark Slate Blue", RGB{0x4a, 0x42, 0x84}, true},
+	{"Slate Blue", RGB{0x7a, 0x71, 0xc4}, true},
+	{"Light Slate Blue", RGB{0xb5, 0xae, 0xf1}, true},
+	{"Light Brown", RGB{0xdb, 0xa4, 0x63}, true},
+	{"Dark Beige", RGB{0xd1, 0x80, 0x51}, true},
+	{"Light Beige", RGB{0xff, 0xc5, 0xa5}, true},
+	{"Dark Peach", RGB{0x9b, 0x52, 0x49}, true},
+	{"Peach", RGB{0xd1, 0x80, 0x78}, true},
+	{"Light Peach", RGB{0xfa, 0xb6, 0xa4}, true},
+	{"Dark Tan", RGB{0x7b, 0x63, 0x52}, true},
+	{"Tan", RGB{0x9c, 0x84, 0x6b}, true},
+	{"Dark Slate", RGB{0x33, 0x39, 0x41}, true},
+	{"Slate", RGB{0x6d, 0x75, 0x8d}, true},
+	{"Light Slate", RGB{0xb3, 0xb9, 0xd1}, true},
+	{"Dark Stone", RGB{0x6d, 0x64, 0x3f}, true},
+	{"Stone", RGB{0x94, 0x8c, 0x6b}, true},
+	{"Light Stone", RGB{0xcd, 0xc5, 0x9e}, true},
+}
+
+var paletteIndex = func() map[uint32]int {
+	m := make(map[uint32]int, len(wplacePalette))
+	for i, c := range wplacePalette {
+		m[c.RGB.packed()] = i
+	}
+	return m
+}()
+
+// Where a colour sits in the palette. Colours that aren't in it sort after every palette colour,
+// by their packed value, so the order is still total and the same every run
+func paletteRank(packed uint32) uint64 {
+	if i, ok := paletteIndex[packed]; ok {
+		return uint64(i)
+	}
+	return uint64(len(wplacePalette)) + uint64(packed)
+}
+
+type colourCount struct {
+	packed uint32
+	count  int
+}
+
+// More pixels wins, ties go to whichever comes first in the palette
+func (a colourCount) beats(b colourCount) bool {
+	if a.count != b.count {
+		return a.count > b.count
+	}
+	return paletteRank(a.packed) < paletteRank(b.packed)
+}
+
+func mostCommon(counts map[uint32]int) (colourCount, bool) {
+	var best colourCount
+	found := false
+	for packed, count := range counts {
+		c := colourCount{packed, count}
+		if !found || c.beats(best) {
+			best, found = c, true
+		}
+	}
+	return best, found
+}
+
+func rankColours(counts map[uint32]int) []colourCount {
+	ranked := make([]colourCount, 0, len(counts))
+	for packed, count := range counts {
+		ranked = append(ranked, colourCount{packed, count})
+	}
+	slices.SortFunc(ranked, func(a, b colourCount) int {
+		if a.beats(b) {
+			return -1
+		}
+		if b.beats(a) {
+			return 1
+		}
+		return 0
+	})
+	return ranked
+}
+
+// Slots for countByPalette, one per palette colour in palette order plus one for anything that isn't
+// in the palette at all
+var paletteSlots = len(wplacePalette) + 1
+
+func paletteSlot(packed uint32) int {
+	if i, ok := paletteIndex[packed]; ok {
+		return i
+	}
+	return len(wplacePalette)
+}
+
+// Adds how many pixels of every palette colour the tile has to counts, which needs paletteSlots entries
+func countByPalette(tile *Tile, counts []uint32) {
+	if tile.paletted() {
+		for i, n := range indexCounts(tile.Index) {
+			if e := tile.Palette[i]; n > 0 && e.painted {
+				counts[paletteSlot(e.packed)] += uint32(n)
+			}
+		}
+		return
+	}
+
+	// Neighbouring pixels are usually the same colour, so the map is only asked when it changes
+	last, slot := uint32(1<<24), 0
+	for i := 0; i < tile.Width*tile.Height*4; i += 4 {
+		if tile.Pix[i+3] == 0 {
+			continue
+		}
+		packed := uint32(tile.Pix[i])<<16 | uint32(tile.Pix[i+1])<<8 | uint32(tile.Pix[i+2])
+		if packed != last {
+			last, slot = packed, paletteSlot(packed)
+		}
+		counts[slot]++
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import "testing"
+
+var (
+	black = wplacePalette[0].RGB
+	white = wplacePalette[4].RGB
+	red   = wplacePalette[6].RGB
+	blue  = wplacePalette[18].RGB
+)
+
+// Before ties went to whichever colour map iteration happened to hit first, so the same tile could
+// get a different mode every run. Now they go to whichever comes first in the palette
+func TestMostCommonTieBreak(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts map[uint32]int
+		want   uint32
+	}{
+		{"more pixels wins", map[uint32]int{red.packed(): 3, black.packed(): 2}, red.packed()},
+		{"tie goes to palette order", map[uint32]int{blue.packed(): 5, red.packed(): 5, white.packed(): 5}, white.packed()},
+		{"palette beats off palette", map[uint32]int{0x123456: 5, blue.packed(): 5}, blue.packed()},
+		{"off palette ties by value", map[uint32]int{0x123456: 5, 0x010101: 5}, 0x010101},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Map order is random, a few goes makes a wrong tie break very likely to show
+			for range 20 {
+				got, ok := mostCommon(tt.counts)
+				if !ok || got.packed != tt.want {
+					t.Fatalf("mostCommon = %06x, want %06x", got.packed, tt.want)
+				}
+			}
+		})
+	}
+
+	if _, ok := mostCommon(map[uint32]int{}); ok {
+		t.Error("no colours shouldn't have a mode")
+	}
+}
+
+func TestRankColours(t *testing.T) {
+	counts := map[uint32]int{0xabcdef: 4, blue.packed(): 4, red.packed(): 9, black.packed(): 1, white.packed(): 4}
+	want := []uint32{red.packed(), white.packed(), blue.packed(), 0xabcdef, black.packed()}
+
+	for range 20 {
+		got := rankColours(counts)
+		for i := range want {
+			if got[i].packed != want[i] {
+				t.Fatalf("rank %d = %06x, want %06x", i, got[i].packed, want[i])
+			}
+		}
+	}
+}
+
+// Both mode and mode-b, on tiles where two colours tie
+func TestModeTies(t *testing.T) {
+	// 2 blue, 2 red, 3 white, 1 transparent
+	img := testPaletted(4, 2, []RGB{blue, red, white}, []uint8{1, 2, 3, 3, 1, 2, 3, 0})
+
+	tests := []struct {
+		token string
+		want  RGB
+	}{
+		{"mb", white},
+		{"m", red},                          // white is boring, red and blue tie and red comes first
+		{"m:x=ffffff+ed1c24", blue},         // only blue left
+		{"m:x=ffffff+ed1c24+4093e4", black}, // nothing left
+	}
+	for _, kind := range tileKinds {
+		tile := testTile(t, img, kind.rgba)
+		for _, tt := range tests {
+			op, err := parseOperation(tt.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := make([]byte, op.RecordSize())
+			if err := op.Process(tile, rec); err != nil {
+				t.Fatal(err)
+			}
+			if got := rgbFromRecord(rec); got != tt.want {
+				t.Errorf("%s %s = %s, want %s", kind.name, tt.token, got.hex(), tt.want.hex())
+			}
+		}
+	}
+}
+
+func TestCountByPalette(t *testing.T) {
+	for _, kind := range tileKinds {
+		img := testPaletted(3, 2, []RGB{red, {1, 2, 3}, red}, []uint8{1, 1, 2, 0, 3, 0})
+		counts := make([]uint32, paletteSlots)
+		countByPalette(testTile(t, img, kind.rgba), counts)
+
+		// The second red index still counts as red, the odd colour lands in the last slot
+		if counts[6] != 3 || counts[len(wplacePalette)] != 1 {
+			t.Errorf("%s: red = %d, other = %d", kind.name, counts[6], counts[len(wplacePalette)])
+		}
+	}
+}
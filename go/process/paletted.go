@@ -0,0 +1,150 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+)
+
+// Wplace saves its tiles as paletted PNGs, a handful of colours with alpha that's only ever 0 or 255.
+// Expanding them to RGBA took longer than decoding them in the first place, so they're kept as one
+// palette index per pixel and every operation works on the indices instead.
+//
+// Set with -n to expand everything to RGBA like it used to, only really there to compare speeds
+var forceRGBA bool
+
+// One palette colour. RGB is premultiplied, same as the RGBA path gets out of draw.Draw, so both
+// paths give the exact same numbers
+type paletteEntry struct {
+	packed  uint32
+	painted bool
+}
+
+// Always 256 long so an index can never be out of range. Indices the PNG doesn't have are transparent
+type tilePalette [256]paletteEntry
+
+func (t *Tile) paletted() bool {
+	return t.Index != nil
+}
+
+// Empty tiles handed to ProcessEmpty have neither
+func (t *Tile) empty() bool {
+	return t.Pix == nil && t.Index == nil
+}
+
+func tileFromFile(filepath string) (*Tile, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return decodeTile(file)
+}
+
+// Only Width, Height and the pixels are filled in, the caller knows where the tile is
+func decodeTile(r io.Reader) (*Tile, error) {
+	img, err := png.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	tile := &Tile{Width: bounds.Dx(), Height: bounds.Dy()}
+
+	switch typedImg := img.(type) {
+	case *image.Paletted:
+		if forceRGBA {
+			tile.Pix = expandRGBA(img)
+			break
+		}
+		tile.Index = compactIndex(typedImg)
+		tile.Palette = newTilePalette(typedImg)
+
+	case *image.RGBA:
+		tile.Pix = typedImg.Pix
+
+	default:
+		tile.Pix = expandRGBA(img)
+	}
+	return tile, nil
+}
+
+func expandRGBA(img image.Image) []uint8 {
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(bounds)
+	draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
+	return rgba.Pix
+}
+
+// png never pads rows, but anything else could
+func compactIndex(img *image.Paletted) []uint8 {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	if img.Stride == width {
+		return img.Pix[:width*height]
+	}
+
+	index := make([]uint8, width*height)
+	for y := range height {
+		copy(index[y*width:(y+1)*width], img.Pix[y*img.Stride:])
+	}
+	return index
+}
+
+func newTilePalette(img *image.Paletted) *tilePalette {
+	var p tilePalette
+	for i, c := range img.Palette {
+		r, g, b, a := c.RGBA()
+		p[i] = paletteEntry{packed: (r>>8)<<16 | (g>>8)<<8 | b>>8, painted: a > 0}
+	}
+	return &p
+}
+
+// How many pixels use each index, everything else about a paletted tile falls out of this
+func indexCounts(index []uint8) *[256]int {
+	var counts [256]int
+	for _, i := range index {
+		counts[i]++
+	}
+	return &counts
+}
+
+// Adds every painted colour's pixel count to counts. Two indices can be the same colour, which is why
+// it goes through the map. Also gives the channel sums for working out the average
+func paletteColourCounts(tile *Tile, counts map[uint32]int) (r, g, b, painted uint64) {
+	for i, n := range indexCounts(tile.Index) {
+		e := tile.Palette[i]
+		if n == 0 || !e.painted {
+			continue
+		}
+
+		counts[e.packed] += n
+		rgb := unpackRGB(e.packed)
+		r += uint64(rgb.R) * uint64(n)
+		g += uint64(rgb.G) * uint64(n)
+		b += uint64(rgb.B) * uint64(n)
+		painted += uint64(n)
+	}
+	return r, g, b, painted
+}
+
+// For the odd thing that needs both sides the same, e.g. diffing against a tile that wasn't paletted
+func (t *Tile) rgba() []uint8 {
+	if !t.paletted() {
+		return t.Pix
+	}
+
+	pix := make([]uint8, len(t.Index)*4)
+	for i, idx := range t.Index {
+		e := t.Palette[idx]
+		if !e.painted {
+			continue
+		}
+		pix[i*4+0] = uint8(e.packed >> 16)
+		pix[i*4+1] = uint8(e.packed >> 8)
+		pix[i*4+2] = uint8(e.packed)
+		pix[i*4+3] = 255
+	}
+	return pix
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Pixels per second through decodeTile and through processTile with the default operations, for
+// both kinds of tile. -n is the old way of doing everything on RGBA
+func BenchmarkDecodeTile(b *testing.B) {
+	data := encodePNG(b, testWorldTile(1))
+	for _, kind := range tileKinds {
+		b.Run(kind.name, func(b *testing.B) {
+			old := forceRGBA
+			forceRGBA = kind.rgba
+			defer func() { forceRGBA = old }()
+
+			for b.Loop() {
+				if _, err := decodeTile(bytes.NewReader(data)); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(b.N)*tileSize*tileSize/b.Elapsed().Seconds(), "pixels/s")
+		})
+	}
+}
+
+func BenchmarkProcessTile(b *testing.B) {
+	data := encodePNG(b, testWorldTile(1))
+	ops, err := parseOperations("c m")
+	if err != nil {
+		b.Fatal(err)
+	}
+	region := Region{X: 5, Y: 5, Width: 1, Height: 1}
+	grids := make([]*Grid, len(ops))
+	for i, op := range ops {
+		grids[i] = newGrid(region, op.RecordSize())
+	}
+
+	for _, kind := range tileKinds {
+		b.Run(kind.name, func(b *testing.B) {
+			old := forceRGBA
+			forceRGBA = kind.rgba
+			defer func() { forceRGBA = old }()
+
+			for b.Loop() {
+				if res := processTile(Job{x: 5, y: 5, data: data}, 1, ops, grids, nil, ""); res.problem != tileOK {
+					b.Fatal(res.detail)
+				}
+			}
+			b.ReportMetric(float64(b.N)*tileSize*tileSize/b.Elapsed().Seconds(), "pixels/s")
+		})
+	}
+}
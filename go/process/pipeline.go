@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"tilearchive"
+)
+
+// Extracted files take up whole blocks, and with millions of tiny tiles that adds up
+const diskBlockSize = 4096
+
+// Keeps a limit on how many folders are extracted at once and how much disk they're allowed to use
+type diskBudget struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	limit       int64 // bytes, 0 = no limit
+	used        int64
+	inFlight    int
+	maxInFlight int
+	kept        int // of inFlight, processed already but still needed by a later folder
+}
+
+func newDiskBudget(limit int64, maxInFlight int) *diskBudget {
+	b := &diskBudget{limit: limit, maxInFlight: max(maxInFlight, 1)}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// Blocks until there's a free slot and room for size more bytes. A folder that's bigger than the
+// whole budget is still let through once nothing else is extracted, otherwise it'd wait forever.
+// Kept folders count against the disk limit but not against the slots, and can't hold up the next
+// folder either, they're waiting on it
+func (b *diskBudget) acquire(size int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for {
+		active := b.inFlight - b.kept
+		if active == 0 || (active < b.maxInFlight && (b.limit == 0 || b.used+size <= b.limit)) {
+			break
+		}
+		b.cond.Wait()
+	}
+
+	if b.limit > 0 && size > b.limit {
+		fmt.Fprintf(os.Stderr, "Warning: a folder needs %s, more than the whole %s budget\n", formatBytes(size), formatBytes(b.limit))
+	}
+
+	b.inFlight++
+	b.used += size
+}
+
+// A processed folder that stays on disk for a later one
+func (b *diskBudget) keep() {
+	b.mu.Lock()
+	b.kept++
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+func (b *diskBudget) release(size int64, kept bool) {
+	b.mu.Lock()
+	b.inFlight--
+	b.used -= size
+	if kept {
+		b.kept--
+	}
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// What an archive will take up once extracted, rounded up to whole blocks per tile
+func extractedSize(archive *tilearchive.Archive) int64 {
+	var total int64
+	for _, e := range archive.Entries() {
+		total += (e.Size + diskBlockSize - 1) / diskBlockSize * diskBlockSize
+	}
+	return total
+}
+
+// For every folder, the index of the last one in folders that reads it (see FolderReader), or its own
+// index if nothing later does
+func lastReaders(folders []int, ops []Operation) []int {
+	index := make(map[int]int, len(folders))
+	last := make([]int, len(folders))
+	for i, folderNum := range folders {
+		index[folderNum] = i
+		last[i] = i
+	}
+	for i, folderNum := range folders {
+		for _, op := range ops {
+			r, ok := op.(FolderReader)
+			if !ok {
+				continue
+			}
+			// Only earlier folders can be kept for it, a later one isn't extracted yet
+			if j, ok := index[r.ReadsFolder(folderNum)]; ok && j < i {
+				last[j] = max(last[j], i)
+			}
+		}
+	}
+	return last
+}
+
+// Extracts folders in order in the background, as many as the budget allows, while process works
+// through them one at a time. Each folder is deleted as soon as it's been processed, or once the
+// last folder that compares against it has, which frees up room for the next extraction
+func runPipeline(folders []int, ops []Operation, tempPath string, budget *diskBudget, process func(folderNum int, tilesFolderPath string)) {
+	type extracted struct {
+		path string
+		size int64
+	}
+
+	ready := make([]chan extracted, len(folders))
+	for i := range ready {
+		ready[i] = make(chan extracted, 1)
+	}
+
+	go func() {
+		for i, folderNum := range folders {
+			archive, err := tilearchive.Open(tilearchive.ArchivePath(wplacePath, folderNum))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+
+			size := extractedSize(archive)
+			budget.acquire(size)
+
+			go func() {
+				p := extractArchive(tempPath, folderNum, archive)
+				archive.Close()
+				ready[i] <- extracted{path: p, size: size}
+			}()
+		}
+	}()
+
+	last := lastReaders(folders, ops)
+	done := make([]extracted, len(folders))
+	for i, folderNum := range folders {
+		done[i] = <-ready[i]
+		process(folderNum, done[i].path)
+
+		if last[i] > i {
+			budget.keep()
+		}
+		for j := range i + 1 {
+			if last[j] == i {
+				deleteTilesFolder(done[j].path)
+				budget.release(done[j].size, j < i)
+			}
+		}
+	}
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLastReaders(t *testing.T) {
+	folders := []int{3, 4, 5, 6}
+	tests := []struct {
+		operations string
+		want       []int
+	}{
+		{"c m", []int{0, 1, 2, 3}},
+		{"c d", []int{1, 2, 3, 3}},
+		{"t", []int{1, 2, 3, 3}},
+		{"g", []int{0, 1, 2, 3}}, // goes by its state, not the folder before
+		{"d:from=3", []int{3, 1, 2, 3}},
+		{"d:from=9", []int{0, 1, 2, 3}},
+		{"d:from=5", []int{0, 1, 3, 3}}, // folders before 5 can't be kept for it
+	}
+	for _, tt := range tests {
+		ops, err := parseOperations(tt.operations)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := lastReaders(folders, ops); !slices.Equal(got, tt.want) {
+			t.Errorf("%q: %v, want %v", tt.operations, got, tt.want)
+		}
+	}
+}
+
+// With one slot, a kept folder mustn't stop the next one being extracted
+func TestDiskBudgetKept(t *testing.T) {
+	b := newDiskBudget(100, 1)
+	b.acquire(60)
+	b.keep()
+
+	done := make(chan struct{})
+	go func() {
+		b.acquire(60)
+		close(done)
+	}()
+	<-done
+
+	b.release(60, true)
+	if b.inFlight != 1 || b.kept != 0 || b.used != 60 {
+		t.Errorf("in flight %d, kept %d, used %d", b.inFlight, b.kept, b.used)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+)
+
+func init() {
+	registerOperation(OperationSpec{
+		Key:    "b",
+		Params: rampParams,
+		Usage:  "premium colours, how many pixels of them each tile has and their share of its painted pixels, plus totals for the folder as json (takes the count ramp params, the share map takes them too except min/max, default linear/viridis)",
+		New: func(o Options) (Operation, error) {
+			ramp, err := newColourRamp(o, tileSize*tileSize, "log", "hsl")
+			if err != nil {
+				return nil, err
+			}
+			// Same look as the count map, but min/max are pixel counts, which mean nothing for a share
+			shareOpts := Options{Flags: o.Flags, Params: maps.Clone(o.Params)}
+			delete(shareOpts.Params, "min")
+			delete(shareOpts.Params, "max")
+			shareRamp, err := newColourRamp(shareOpts, shareScale, "linear", "viridis")
+			if err != nil {
+				return nil, err
+			}
+			return premiumOperation{ramp: ramp, shareRamp: shareRamp}, nil
+		},
+	})
+}
+
+// The record is the premium pixel count, then the painted pixel count to take a share of
+type premiumOperation struct {
+	pureOperation
+	ramp, shareRamp *colourRamp
+}
+
+func (op premiumOperation) Name() string {
+	return "premium" + op.ramp.suffix()
+}
+
+func (premiumOperation) RecordSize() int {
+	return 8
+}
+
+func (premiumOperation) Process(tile *Tile, rec []byte) error {
+	counts := make([]uint32, paletteSlots)
+	countByPalette(tile, counts)
+
+	var premium, painted uint32
+	for i, n := range counts {
+		painted += n
+		if i < len(wplacePalette) && wplacePalette[i].Premium {
+			premium += n
+		}
+	}
+	binary.LittleEndian.PutUint32(rec[0:4], premium)
+	binary.LittleEndian.PutUint32(rec[4:8], painted)
+	return nil
+}
+
+func premiumFromRecord(rec []byte) (premium, painted uint32) {
+	return binary.LittleEndian.Uint32(rec[0:4]), binary.LittleEndian.Uint32(rec[4:8])
+}
+
+func (op premiumOperation) Encode(out *Output, grid *Grid) error {
+	if err := op.saveCSV(out.Path(op.Name(), "csv"), grid); err != nil {
+		return err
+	}
+	if err := op.saveTotals(out.Path(op.Name(), "json"), out.Folder, grid); err != nil {
+		return err
+	}
+
+	return saveTileMaps(out, grid, []tileMap{
+		{op.Name(), op.ramp, func(rec []byte) uint32 {
+			premium, _ := premiumFromRecord(rec)
+			return premium
+		}, "premium pixels per tile", formatPixels},
+		{op.Name() + "-share", op.shareRamp, func(rec []byte) uint32 {
+			premium, painted := premiumFromRecord(rec)
+			if painted == 0 {
+				return 0
+			}
+			return uint32(uint64(premium) * shareScale / uint64(painted))
+		}, "premium share of painted pixels", func(v float64) string {
+			return fmt.Sprintf("%.1f%%", v*100/shareScale)
+		}},
+	})
+}
+
+// One row per tile with any premium pixels: x,y,premium,painted
+func (op premiumOperation) saveCSV(outputPath string, grid *Grid) error {
+	return saveTileCSV(outputPath, "x,y,premium,painted", grid, func(x, y int, rec []byte) string {
+		premium, painted := premiumFromRecord(rec)
+		if premium == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%d,%d", premium, painted)
+	})
+}
+
+// The whole folder in one go. Per colour totals are in the summary operation's json
+func (op premiumOperation) saveTotals(outputPath string, folder int, grid *Grid) error {
+	fmt.Fprintf(os.Stderr, "Saving premium totals %s to disk...", outputPath)
+
+	totals := struct {
+		Folder       int     `json:"folder"`
+		Region       string  `json:"region,omitempty"`
+		Painted      uint64  `json:"painted"`
+		Premium      uint64  `json:"premium"`
+		Share        float64 `json:"share"`
+		Tiles        int     `json:"tiles"`
+		PremiumTiles int     `json:"premium_tiles"`
+	}{Folder: folder}
+	if !grid.isWorld() {
+		totals.Region = grid.Region.String()
+	}
+
+	for i := range grid.Width * grid.Height {
+		premium, painted := premiumFromRecord(grid.Data[i*8:])
+		totals.Premium += uint64(premium)
+		totals.Painted += uint64(painted)
+		if painted > 0 {
+			totals.Tiles++
+		}
+		if premium > 0 {
+			totals.PremiumTiles++
+		}
+	}
+	if totals.Painted > 0 {
+		totals.Share = float64(totals.Premium) / float64(totals.Painted)
+	}
+
+	data, err := json.MarshalIndent(totals, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(outputPath, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Data saved successfully!\n")
+	return nil
+}
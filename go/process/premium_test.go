@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+// The share map looks like the count map but keeps its own 0-100% range
+func TestPremiumShareRamp(t *testing.T) {
+	tests := []struct {
+		token      string
+		scale      string
+		cmap       string
+		max        float64
+		countScale string
+	}{
+		{"b", "linear", "viridis", shareScale, "log"},
+		{"b:cmap=magma", "linear", "magma", shareScale, "log"},
+		{"b:scale=quantile:max=500", "quantile", "viridis", shareScale, "quantile"},
+		{"b:min=10:max=500:hexp=1.2", "linear", "viridis", shareScale, "log"},
+	}
+	for _, tt := range tests {
+		op, err := parseOperation(tt.token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := op.(premiumOperation)
+		share := p.shareRamp
+		if share.scale != tt.scale || share.cmap != tt.cmap || share.min != 0 || share.max != tt.max {
+			t.Errorf("%s: share ramp %s/%s %v-%v, want %s/%s 0-%v", tt.token, share.scale, share.cmap, share.min, share.max, tt.scale, tt.cmap, tt.max)
+		}
+		if p.ramp.scale != tt.countScale {
+			t.Errorf("%s: count ramp scale %s, want %s", tt.token, p.ramp.scale, tt.countScale)
+		}
+	}
+
+	op, _ := parseOperation("b:hexp=1.2")
+	if got := op.(premiumOperation).shareRamp.hueExp; got != 1.2 {
+		t.Errorf("share ramp hexp = %v, want 1.2", got)
+	}
+}
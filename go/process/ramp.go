@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"slices"
+	"sort"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+var rampScales = map[string]bool{"log": true, "linear": true, "quantile": true}
+
+// 11 evenly spaced stops, same as matplotlib's
+var rampColormaps = map[string][]RGB{
+	"hsl": nil,
+	"viridis": {
+		{0x44, 0x01, 0x54}, {0x48, 0x24, 0x75}, {0x41, 0x44, 0x87}, {0x35, 0x5f, 0x8d}, {0x2a, 0x78, 0x8e}, {0x21, 0x91, 0x8c},
+		{0x22, 0xa8, 0x84}, {0x44, 0xbf, 0x70}, {0x7a, 0xd1, 0x51}, {0xbd, 0xdf, 0x26}, {0xfd, 0xe7, 0x25},
+	},
+	"magma": {
+		{0x00, 0x00, 0x04}, {0x14, 0x0e, 0x36}, {0x3b, 0x0f, 0x70}, {0x64, 0x1a, 0x80}, {0x8c, 0x29, 0x81}, {0xb7, 0x37, 0x79},
+		{0xde, 0x49, 0x68}, {0xf7, 0x70, 0x5c}, {0xfe, 0x9f, 0x6d}, {0xfe, 0xcf, 0x92}, {0xfc, 0xfd, 0xbf},
+	},
+}
+
+var rampParams = []string{"scale", "cmap", "min", "max", "half", "hexp", "lexp", "lmax"}
+
+// rampParams without min/max, for maps of something other than pixel counts (entropy, shares, etc.)
+var rampShapeParams = []string{"scale", "cmap", "half", "hexp", "lexp", "lmax"}
+
+const rampUsage = "scale=log/linear/quantile, cmap=hsl/viridis/magma, min/max=count thresholds, half=log midpoint fraction, hexp/lexp/lmax=hsl curve"
+
+// Turns a pixel count into a colour. The scale squashes the count into 0..1, the colormap colours that
+type colourRamp struct {
+	scale string
+	cmap  string
+
+	defaultScale, defaultCmap string
+
+	// Counts outside of these are clamped
+	min, max float64
+
+	// Log only, the fraction of the range that lands halfway up the ramp
+	half     float64
+	valueExp float64
+
+	// HSL only. hueExp >1 = linger near red longer, lightExp >1 = darker early, lightMax = brightness ceiling
+	hueExp, lightExp, lightMax float64
+
+	// Quantile only, every non-empty count in the folder, set by fit
+	sorted []float64
+}
+
+func newColourRamp(o Options, maxCount int, defaultScale, defaultCmap string) (*colourRamp, error) {
+	r := &colourRamp{
+		scale:        o.String("scale", defaultScale),
+		cmap:         o.String("cmap", defaultCmap),
+		defaultScale: defaultScale,
+		defaultCmap:  defaultCmap,
+	}
+
+	if !rampScales[r.scale] {
+		return nil, fmt.Errorf("unknown scale %q", r.scale)
+	}
+	if _, ok := rampColormaps[r.cmap]; !ok {
+		return nil, fmt.Errorf("unknown colormap %q", r.cmap)
+	}
+
+	var err error
+	floats := []struct {
+		name string
+		dst  *float64
+		def  float64
+	}{
+		{"min", &r.min, 0},
+		{"max", &r.max, float64(maxCount)},
+		{"half", &r.half, 0.01},
+		{"hexp", &r.hueExp, 0.8},
+		{"lexp", &r.lightExp, 1.6},
+		{"lmax", &r.lightMax, 0.9},
+	}
+	for _, f := range floats {
+		if *f.dst, err = o.Float(f.name, f.def); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.max <= r.min {
+		return nil, fmt.Errorf("max (%g) has to be above min (%g)", r.max, r.min)
+	}
+	if r.half <= 0 || r.half >= 1 {
+		return nil, fmt.Errorf("half (%g) has to be between 0 and 1", r.half)
+	}
+
+	span := r.max - r.min
+	nf := math.Log1p(r.half*span) / math.Log1p(span)
+	r.valueExp = math.Log(0.5) / math.Log(nf)
+
+	return r, nil
+}
+
+// The default ramp keeps the plain old name, anything else says what it is
+func (r *colourRamp) suffix() string {
+	suffix := ""
+	if r.scale != r.defaultScale {
+		suffix += "-" + r.scale
+	}
+	if r.cmap != r.defaultCmap {
+		suffix += "-" + r.cmap
+	}
+	return suffix
+}
+
+func (r *colourRamp) fit(counts []uint32) {
+	if r.scale != "quantile" {
+		return
+	}
+
+	r.sorted = r.sorted[:0]
+	for _, c := range counts {
+		if c > 0 {
+			r.sorted = append(r.sorted, r.clamp(float64(c)))
+		}
+	}
+	slices.Sort(r.sorted)
+}
+
+func (r *colourRamp) clamp(count float64) float64 {
+	return math.Min(math.Max(count, r.min), r.max)
+}
+
+func (r *colourRamp) value(count float64) float64 {
+	c := r.clamp(count)
+	span := r.max - r.min
+
+	switch r.scale {
+	case "linear":
+		return (c - r.min) / span
+
+	case "quantile":
+		if len(r.sorted) == 0 {
+			return 0
+		}
+		n := sort.Search(len(r.sorted), func(i int) bool { return r.sorted[i] > c })
+		return float64(n) / float64(len(r.sorted))
+
+	default:
+		norm := math.Log1p(c-r.min) / math.Log1p(span)
+		return math.Pow(norm, r.valueExp)
+	}
+}
+
+// The inverse of value, for labelling the legend
+func (r *colourRamp) countAt(v float64) float64 {
+	span := r.max - r.min
+
+	switch r.scale {
+	case "linear":
+		return r.min + v*span
+
+	case "quantile":
+		if len(r.sorted) == 0 {
+			return 0
+		}
+		i := int(math.Ceil(v*float64(len(r.sorted)))) - 1
+		return r.sorted[clampInt(i, 0, len(r.sorted)-1)]
+
+	default:
+		norm := math.Pow(v, 1/r.valueExp)
+		return r.min + math.Expm1(norm*math.Log1p(span))
+	}
+}
+
+func (r *colourRamp) colourOf(v float64) RGB {
+	stops := rampColormaps[r.cmap]
+	if stops == nil {
+		hue := math.Pow(v, r.hueExp)
+		light := math.Pow(v, r.lightExp) * r.lightMax
+		return hslToRgb(HSL{H: hue, S: 1, L: light})
+	}
+
+	pos := v * float64(len(stops)-1)
+	i := clampInt(int(pos), 0, len(stops)-2)
+	t := pos - float64(i)
+	a, b := stops[i], stops[i+1]
+	lerp := func(x, y uint8) uint8 { return uint8(math.Round(float64(x) + (float64(y)-float64(x))*t)) }
+	return RGB{R: lerp(a.R, b.R), G: lerp(a.G, b.G), B: lerp(a.B, b.B)}
+}
+
+// Empty tiles are always black, whatever the colormap starts at
+func (r *colourRamp) colour(count float64) RGB {
+	if count <= 0 {
+		return RGB{0, 0, 0}
+	}
+	return r.colourOf(r.value(count))
+}
+
+// A bar going from the top of the ramp down to the bottom, with the counts each colour stands for
+func (r *colourRamp) legend(title string, label func(float64) string) image.Image {
+	const (
+		width   = 360
+		height  = 340
+		barX    = 16
+		barW    = 40
+		barTop  = 40
+		barH    = 256
+		ticks   = 8
+		textX   = barX + barW + 12
+		lineGap = 13
+	)
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+
+	d := &font.Drawer{Dst: img, Src: image.NewUniform(color.White), Face: basicfont.Face7x13}
+	text := func(x, y int, s string) {
+		d.Dot = fixed.P(x, y)
+		d.DrawString(s)
+	}
+
+	text(barX, 16, title)
+	text(barX, 16+lineGap, fmt.Sprintf("scale=%s cmap=%s", r.scale, r.cmap))
+
+	for row := range barH {
+		v := 1 - float64(row)/float64(barH-1)
+		rgb := r.colourOf(v)
+		c := color.RGBA{rgb.R, rgb.G, rgb.B, 255}
+		for x := barX; x < barX+barW; x++ {
+			img.SetRGBA(x, barTop+row, c)
+		}
+	}
+
+	grey := color.RGBA{128, 128, 128, 255}
+	for i := range ticks + 1 {
+		v := 1 - float64(i)/float64(ticks)
+		y := barTop + int(math.Round(float64(i)/float64(ticks)*float64(barH-1)))
+		for x := barX + barW; x < barX+barW+6; x++ {
+			img.SetRGBA(x, y, grey)
+		}
+		text(textX, y+4, label(r.countAt(v)))
+	}
+
+	// Empty tiles get their own swatch, they're black no matter the ramp
+	swatchY := barTop + barH + 14
+	for y := swatchY; y < swatchY+14; y++ {
+		for x := barX; x < barX+barW; x++ {
+			if y == swatchY || y == swatchY+13 || x == barX || x == barX+barW-1 {
+				img.SetRGBA(x, y, grey)
+			}
+		}
+	}
+	text(textX, swatchY+11, "empty")
+
+	return img
+}
+
+func formatPixels(c float64) string {
+	return formatCount(c) + " px"
+}
+
+func formatCount(c float64) string {
+	c = math.Round(c)
+	switch {
+	case c >= 1_000_000:
+		return fmt.Sprintf("%.4gM", c/1_000_000)
+	case c >= 10_000:
+		return fmt.Sprintf("%.4gk", c/1_000)
+	default:
+		return fmt.Sprintf("%.0f", c)
+	}
+}
+
+func clampInt(x, lo, hi int) int {
+	if x < lo {
+		return lo
+	}
+	if x > hi {
+		return hi
+	}
+	return x
+}
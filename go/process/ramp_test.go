@@ -0,0 +1,124 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func testRamp(t *testing.T, params map[string]string, maxCount int) *colourRamp {
+	t.Helper()
+	r, err := newColourRamp(Options{Params: params}, maxCount, "log", "hsl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRampValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]string
+		count  float64
+		want   float64
+	}{
+		{"linear bottom", map[string]string{"scale": "linear"}, 0, 0},
+		{"linear middle", map[string]string{"scale": "linear"}, 500, 0.5},
+		{"linear top", map[string]string{"scale": "linear"}, 1000, 1},
+		{"linear clamped above", map[string]string{"scale": "linear"}, 5000, 1},
+		{"linear clamped below", map[string]string{"scale": "linear", "min": "100"}, 50, 0},
+		{"linear with min", map[string]string{"scale": "linear", "min": "100"}, 550, 0.5},
+		{"log bottom", nil, 0, 0},
+		{"log top", nil, 1000, 1},
+		{"log half lands halfway", nil, 10, 0.5},
+		{"log other half", map[string]string{"half": "0.25"}, 250, 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := testRamp(t, tt.params, 1000)
+			if got := r.value(tt.count); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("value(%g) = %g, want %g", tt.count, got, tt.want)
+			}
+		})
+	}
+}
+
+// countAt labels the legend, so it has to undo value
+func TestRampCountAtInvertsValue(t *testing.T) {
+	for _, scale := range []string{"linear", "log"} {
+		r := testRamp(t, map[string]string{"scale": scale, "min": "3"}, 1_000_000)
+		for _, v := range []float64{0, 0.1, 0.25, 0.5, 0.75, 0.9, 1} {
+			if got := r.value(r.countAt(v)); math.Abs(got-v) > 1e-9 {
+				t.Errorf("%s: value(countAt(%g)) = %g", scale, v, got)
+			}
+		}
+	}
+}
+
+func TestRampQuantile(t *testing.T) {
+	r := testRamp(t, map[string]string{"scale": "quantile"}, 1000)
+	r.fit([]uint32{0, 4, 1, 0, 3, 2})
+
+	values := []struct {
+		count, want float64
+	}{
+		{0, 0},
+		{1, 0.25},
+		{2, 0.5},
+		{2.5, 0.5},
+		{4, 1},
+		{10_000, 1},
+	}
+	for _, tt := range values {
+		if got := r.value(tt.count); got != tt.want {
+			t.Errorf("value(%g) = %g, want %g", tt.count, got, tt.want)
+		}
+	}
+
+	counts := []struct {
+		v, want float64
+	}{
+		{0, 1},
+		{0.25, 1},
+		{0.5, 2},
+		{0.75, 3},
+		{1, 4},
+	}
+	for _, tt := range counts {
+		if got := r.countAt(tt.v); got != tt.want {
+			t.Errorf("countAt(%g) = %g, want %g", tt.v, got, tt.want)
+		}
+	}
+
+	// Nothing painted anywhere shouldn't divide by zero
+	r.fit([]uint32{0, 0})
+	if got := r.value(5); got != 0 {
+		t.Errorf("empty fit: value = %g, want 0", got)
+	}
+	if got := r.countAt(0.5); got != 0 {
+		t.Errorf("empty fit: countAt = %g, want 0", got)
+	}
+}
+
+func TestRampParams(t *testing.T) {
+	bad := []map[string]string{
+		{"scale": "cubic"},
+		{"cmap": "jet"},
+		{"min": "10", "max": "10"},
+		{"half": "0"},
+		{"half": "1"},
+		{"max": "lots"},
+	}
+	for _, params := range bad {
+		if _, err := newColourRamp(Options{Params: params}, 1000, "log", "hsl"); err == nil {
+			t.Errorf("%v should be rejected", params)
+		}
+	}
+
+	r := testRamp(t, map[string]string{"scale": "linear", "cmap": "magma"}, 1000)
+	if got := r.suffix(); got != "-linear-magma" {
+		t.Errorf("suffix = %q", got)
+	}
+	if got := r.colour(0); got != (RGB{}) {
+		t.Errorf("colour(0) = %v, empty tiles should be black", got)
+	}
+}
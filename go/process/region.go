@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// wplace is 2048x2048 tiles
+const worldSize = 2048
+
+// A rectangle of tiles, X/Y being the top left one
+type Region struct {
+	X, Y          int
+	Width, Height int
+}
+
+func worldRegion() Region {
+	return Region{Width: worldSize, Height: worldSize}
+}
+
+func (r Region) isWorld() bool {
+	return r == worldRegion()
+}
+
+func (r Region) tiles() int {
+	return r.Width * r.Height
+}
+
+func (r Region) String() string {
+	return fmt.Sprintf("%d-%d-%dx%d", r.X, r.Y, r.Width, r.Height)
+}
+
+var linkRegex = regexp.MustCompile(`lat=(-?[\d.]+)&lng=(-?[\d.]+)`)
+
+// Either left,top,right,bottom in tiles (inclusive, same as extract and combine), or a wplace.live
+// link, in which case it's the link's tile and around tiles either side of it
+func parseRegion(s string, around int) (Region, error) {
+	if matches := linkRegex.FindStringSubmatch(s); matches != nil {
+		lat, err := strconv.ParseFloat(matches[1], 64)
+		if err != nil {
+			return Region{}, fmt.Errorf("bad lat in %q", s)
+		}
+		lng, err := strconv.ParseFloat(matches[2], 64)
+		if err != nil {
+			return Region{}, fmt.Errorf("bad lng in %q", s)
+		}
+
+		x, y := latLngToTile(lat, lng)
+		return clampRegion(x-around, y-around, x+around, y+around)
+	}
+
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return Region{}, fmt.Errorf("region %q should be left,top,right,bottom or a wplace.live link", s)
+	}
+	var nums [4]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return Region{}, fmt.Errorf("region %q: %w", s, err)
+		}
+		nums[i] = n
+	}
+
+	left, top, right, bottom := nums[0], nums[1], nums[2], nums[3]
+	if right < left || bottom < top {
+		return Region{}, fmt.Errorf("region %q is backwards", s)
+	}
+	if left < 0 || top < 0 || right >= worldSize || bottom >= worldSize {
+		return Region{}, fmt.Errorf("region %q goes outside the 0-%d tiles", s, worldSize-1)
+	}
+	return Region{X: left, Y: top, Width: right - left + 1, Height: bottom - top + 1}, nil
+}
+
+// Links near the edge of the world just get cut short instead of erroring
+func clampRegion(left, top, right, bottom int) (Region, error) {
+	left, top = max(left, 0), max(top, 0)
+	right, bottom = min(right, worldSize-1), min(bottom, worldSize-1)
+	if right < left || bottom < top {
+		return Region{}, errors.New("region is empty")
+	}
+	return Region{X: left, Y: top, Width: right - left + 1, Height: bottom - top + 1}, nil
+}
+
+// Web mercator, same as tools/coords.ts
+func latLngToTile(lat, lng float64) (x, y int) {
+	const maxLat = 85.05112878 * math.Pi / 180
+
+	phi := max(min(lat*math.Pi/180, maxLat), -maxLat)
+	yNorm := (1 - math.Log(math.Tan(phi/2+math.Pi/4))/math.Pi) / 2
+
+	x = int(math.Floor((lng + 180) / 360 * worldSize))
+	y = int(math.Floor(yNorm * worldSize))
+	return max(min(x, worldSize-1), 0), max(min(y, worldSize-1), 0)
+}
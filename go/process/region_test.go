@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRegion(t *testing.T) {
+	tests := []struct {
+		s      string
+		around int
+		want   Region
+		err    string // substring, "" = no error
+	}{
+		{"1860,1281,1860,1282", 0, Region{X: 1860, Y: 1281, Width: 1, Height: 2}, ""},
+		{" 0, 0 ,2047,2047", 0, worldRegion(), ""},
+		{"10,20,15,20", 5, Region{X: 10, Y: 20, Width: 6, Height: 1}, ""}, // around is only for links
+		{"https://wplace.live/?lat=0&lng=0&zoom=11", 0, Region{X: 1024, Y: 1024, Width: 1, Height: 1}, ""},
+		{"https://wplace.live/?lat=0&lng=0&zoom=11", 2, Region{X: 1022, Y: 1022, Width: 5, Height: 5}, ""},
+		{"lat=85.1&lng=-180", 3, Region{X: 0, Y: 0, Width: 4, Height: 4}, ""}, // cut short at the corner
+		{"lat=-90&lng=179.99", 1, Region{X: 2046, Y: 2046, Width: 2, Height: 2}, ""},
+
+		{"1,2,3", 0, Region{}, "should be left,top,right,bottom"},
+		{"", 0, Region{}, "should be left,top,right,bottom"},
+		{"a,2,3,4", 0, Region{}, "invalid syntax"},
+		{"5,2,3,4", 0, Region{}, "backwards"},
+		{"1,5,3,4", 0, Region{}, "backwards"},
+		{"-1,0,3,4", 0, Region{}, "outside"},
+		{"0,0,2048,4", 0, Region{}, "outside"},
+		{"lat=1.2.3&lng=0", 0, Region{}, "bad lat"},
+		{"lat=0&lng=.", 0, Region{}, "bad lng"},
+		{"lat=0&lng=-", 0, Region{}, "should be left,top,right,bottom"},
+	}
+	for _, tt := range tests {
+		got, err := parseRegion(tt.s, tt.around)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: error %v, want one containing %q", tt.s, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.s, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q around %d = %+v, want %+v", tt.s, tt.around, got, tt.want)
+		}
+	}
+}
+
+func TestLatLngToTile(t *testing.T) {
+	tests := []struct {
+		lat, lng float64
+		x, y     int
+	}{
+		{0, 0, 1024, 1024},
+		{0, -180, 0, 1024},
+		{0, 180, 2047, 1024},
+		{89, 0, 1024, 0}, // past web mercator's edge, clamped
+		{-89, 0, 1024, 2047},
+		{85.05112878, -180, 0, 0},
+	}
+	for _, tt := range tests {
+		if x, y := latLngToTile(tt.lat, tt.lng); x != tt.x || y != tt.y {
+			t.Errorf("%v,%v = %d,%d, want %d,%d", tt.lat, tt.lng, x, y, tt.x, tt.y)
+		}
+	}
+}
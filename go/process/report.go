@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"image/png"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// What went wrong with a tile. Kept in its own one byte per tile grid so it survives a checkpoint
+// like everything else, 0 = fine (or empty)
+type tileProblem uint8
+
+const (
+	tileOK tileProblem = iota
+	tileMissing
+	tileTruncated
+	tileUndecodable
+	tileWrongSize
+	tileFailed // an operation returned an error for it
+)
+
+var tileProblemNames = [...]string{"ok", "missing", "truncated", "undecodable", "wrong-size", "failed"}
+
+func (p tileProblem) String() string {
+	return tileProblemNames[p]
+}
+
+// Anything that goes wrong before there's a decoded tile
+func classifyReadError(err error) tileProblem {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return tileMissing
+	// png says this when the image data stops early rather than returning an EOF
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF), errors.Is(err, png.FormatError("not enough pixel data")):
+		return tileTruncated
+	default:
+		return tileUndecodable
+	}
+}
+
+// Every bad tile in a folder, written to <data>/<folder>-errors.csv whether there are any or not,
+// so an old report never hangs around after the folder's been fixed
+type tileReport struct {
+	status *Grid
+
+	// The error message for every bad tile, keyed by real tile numbers. Checkpoints keep these too,
+	// so a resumed run's report is the same as one that went straight through. Remote columns come
+	// in on their own goroutines, hence the lock
+	mu      sync.Mutex
+	details map[[2]int]string
+}
+
+func newTileReport(region Region) *tileReport {
+	return &tileReport{status: newGrid(region, 1), details: make(map[[2]int]string)}
+}
+
+// x/y are relative to the region, like Grid.At
+func (r *tileReport) problem(x, y int) tileProblem {
+	return tileProblem(r.status.At(x, y)[0])
+}
+
+func (r *tileReport) add(res Result) {
+	if res.problem != tileOK {
+		r.setDetail(res.x, res.y, res.detail)
+	}
+}
+
+// x/y are real tile numbers
+func (r *tileReport) setDetail(x, y int, detail string) {
+	r.mu.Lock()
+	r.details[[2]int{x, y}] = detail
+	r.mu.Unlock()
+}
+
+func (r *tileReport) detail(x, y int) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.details[[2]int{x, y}]
+}
+
+func (r *tileReport) save(out *Output) (int, error) {
+	file, err := os.Create(out.Path("errors", "csv"))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	// Details are error messages, so they need proper quoting
+	w := csv.NewWriter(file)
+	w.Write([]string{"x", "y", "problem", "detail"})
+
+	bad := 0
+	for x := range r.status.Width {
+		for y := range r.status.Height {
+			p := r.problem(x, y)
+			if p == tileOK {
+				continue
+			}
+			bad++
+			tx, ty := r.status.X+x, r.status.Y+y
+			w.Write([]string{strconv.Itoa(tx), strconv.Itoa(ty), p.String(), r.detail(tx, ty)})
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return 0, err
+	}
+	return bad, file.Close()
+}
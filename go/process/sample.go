@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+
+	"tilearchive"
+)
+
+// With -q, only this fraction of the tiles that exist gets processed, and instead of the usual outputs
+// there's an estimate of the whole folder's totals. 0 = off
+var sampleRate float64
+
+// Set with -v, with -q also save a rough count map
+var samplePreview bool
+
+// Tiles are sampled separately in blocks this big, so a sample can't all land in one corner
+const sampleBlock = 64
+
+// 95% confidence intervals
+const sampleZ = 1.96
+
+// One block of the region and which of its existing tiles got picked
+type sampleStratum struct {
+	existing int
+	picked   []int // indices into tileSample.picked
+}
+
+// A stratified random sample of a folder's existing tiles. Picked tiles are in x then y order, same
+// as everything else goes through the tiles
+type tileSample struct {
+	region   Region
+	blocksY  int
+	strata   []sampleStratum
+	picked   [][2]int
+	position map[[2]int]int
+	existing []bool // grid order, x*Height+y relative to the region
+}
+
+func (s *tileSample) stratumOf(x, y int) int {
+	return (x-s.region.X)/sampleBlock*s.blocksY + (y-s.region.Y)/sampleBlock
+}
+
+// Every block gets rate of its existing tiles, rounded, but at least 2 so it has a spread to go by.
+// The same folder and rate always pick the same tiles
+func newTileSample(folder int, region Region, rate float64, has func(x, y int) bool) *tileSample {
+	blocksX := (region.Width + sampleBlock - 1) / sampleBlock
+	s := &tileSample{
+		region:   region,
+		blocksY:  (region.Height + sampleBlock - 1) / sampleBlock,
+		position: make(map[[2]int]int),
+		existing: make([]bool, region.tiles()),
+	}
+	s.strata = make([]sampleStratum, blocksX*s.blocksY)
+
+	candidates := make([][][2]int, len(s.strata))
+	for x := region.X; x < region.X+region.Width; x++ {
+		for y := region.Y; y < region.Y+region.Height; y++ {
+			if !has(x, y) {
+				continue
+			}
+			s.existing[(x-region.X)*region.Height+(y-region.Y)] = true
+			h := s.stratumOf(x, y)
+			candidates[h] = append(candidates[h], [2]int{x, y})
+		}
+	}
+
+	rng := rand.New(rand.NewPCG(uint64(folder), math.Float64bits(rate)))
+	for h, c := range candidates {
+		s.strata[h].existing = len(c)
+		n := min(len(c), max(2, int(math.Round(rate*float64(len(c))))))
+
+		// Only as much of a shuffle as it takes to pick n
+		for i := range n {
+			j := i + rng.IntN(len(c)-i)
+			c[i], c[j] = c[j], c[i]
+		}
+		s.picked = append(s.picked, c[:n]...)
+	}
+
+	slices.SortFunc(s.picked, func(a, b [2]int) int {
+		if a[0] != b[0] {
+			return a[0] - b[0]
+		}
+		return a[1] - b[1]
+	})
+	for i, p := range s.picked {
+		s.position[p] = i
+		h := s.stratumOf(p[0], p[1])
+		s.strata[h].picked = append(s.strata[h].picked, i)
+	}
+	return s
+}
+
+// Stands in for the -o operations while sampling. The grid only gets the painted count, everything
+// else is kept for the sampled tiles alone, a per colour record for every tile in the world would be
+// a gigabyte for a run that's meant to take seconds
+type estimateOperation struct {
+	sample *tileSample
+	counts []uint32 // paletteSlots per sampled tile, in sample order
+}
+
+func newEstimateOperation(sample *tileSample) *estimateOperation {
+	return &estimateOperation{sample: sample, counts: make([]uint32, len(sample.picked)*paletteSlots)}
+}
+
+func (*estimateOperation) Name() string {
+	return "estimate"
+}
+
+func (*estimateOperation) RecordSize() int {
+	return 4
+}
+
+func (op *estimateOperation) Process(tile *Tile, rec []byte) error {
+	i, ok := op.sample.position[[2]int{tile.X, tile.Y}]
+	if !ok {
+		return fmt.Errorf("tile %d,%d wasn't sampled", tile.X, tile.Y)
+	}
+
+	counts := op.counts[i*paletteSlots : (i+1)*paletteSlots]
+	countByPalette(tile, counts)
+
+	var painted uint32
+	for _, n := range counts {
+		painted += n
+	}
+	binary.LittleEndian.PutUint32(rec, painted)
+	return nil
+}
+
+type sampleEstimate struct {
+	Estimate float64 `json:"estimate"`
+	Low      float64 `json:"low"`
+	High     float64 `json:"high"`
+}
+
+type colourEstimate struct {
+	Name string `json:"name"`
+	Hex  string `json:"hex,omitempty"`
+	sampleEstimate
+}
+
+// The usual stratified estimator: every block's mean times its number of tiles, with the variance
+// of that from the spread within each block. Tiles that couldn't be read are left out of their
+// block's mean, a block with none left isn't counted at all
+func (op *estimateOperation) estimate(ok []bool, value func(i int) float64) sampleEstimate {
+	var total, variance float64
+	for _, st := range op.sample.strata {
+		var values []float64
+		for _, i := range st.picked {
+			if ok[i] {
+				values = append(values, value(i))
+			}
+		}
+		n := float64(len(values))
+		if n == 0 {
+			continue
+		}
+
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		mean := sum / n
+		total += float64(st.existing) * mean
+
+		if n < 2 {
+			continue
+		}
+		var squares float64
+		for _, v := range values {
+			squares += (v - mean) * (v - mean)
+		}
+		N := float64(st.existing)
+		variance += N * N * (1 - n/N) * squares / (n - 1) / n
+	}
+
+	half := sampleZ * math.Sqrt(variance)
+	return sampleEstimate{Estimate: math.Round(total), Low: math.Max(0, math.Round(total-half)), High: math.Round(total + half)}
+}
+
+func (op *estimateOperation) Encode(out *Output, grid *Grid) error {
+	s := op.sample
+
+	ok := make([]bool, len(s.picked))
+	readable := 0
+	for i, p := range s.picked {
+		ok[i] = out.report.problem(p[0]-grid.X, p[1]-grid.Y) == tileOK
+		if ok[i] {
+			readable++
+		}
+	}
+
+	count := func(i, slot int) float64 {
+		return float64(op.counts[i*paletteSlots+slot])
+	}
+	painted := func(i int) float64 {
+		return float64(binary.LittleEndian.Uint32(grid.AtTile(s.picked[i][0], s.picked[i][1])))
+	}
+
+	existing := countExisting(s)
+
+	result := struct {
+		Folder       int              `json:"folder"`
+		Region       string           `json:"region,omitempty"`
+		Rate         float64          `json:"rate"`
+		Confidence   float64          `json:"confidence"`
+		Tiles        int              `json:"tiles"`
+		Sampled      int              `json:"sampled"`
+		Readable     int              `json:"readable"`
+		PaintedTiles sampleEstimate   `json:"painted_tiles"`
+		Painted      sampleEstimate   `json:"painted"`
+		Premium      sampleEstimate   `json:"premium"`
+		Colours      []colourEstimate `json:"colours"`
+	}{
+		Folder:     out.Folder,
+		Rate:       sampleRate,
+		Confidence: 0.95,
+		Tiles:      existing,
+		Sampled:    len(s.picked),
+		Readable:   readable,
+	}
+	if !grid.isWorld() {
+		result.Region = grid.Region.String()
+	}
+
+	result.Painted = op.estimate(ok, painted)
+	result.PaintedTiles = op.estimate(ok, func(i int) float64 {
+		return math.Min(painted(i), 1)
+	})
+	result.Premium = op.estimate(ok, func(i int) float64 {
+		var n float64
+		for slot, c := range wplacePalette {
+			if c.Premium {
+				n += count(i, slot)
+			}
+		}
+		return n
+	})
+	for slot, c := range wplacePalette {
+		e := op.estimate(ok, func(i int) float64 { return count(i, slot) })
+		result.Colours = append(result.Colours, colourEstimate{Name: c.Name, Hex: c.RGB.hex(), sampleEstimate: e})
+	}
+	if e := op.estimate(ok, func(i int) float64 { return count(i, len(wplacePalette)) }); e.Estimate > 0 {
+		result.Colours = append(result.Colours, colourEstimate{Name: "Other", sampleEstimate: e})
+	}
+
+	fmt.Printf("Estimated from %d of %d tiles (95%% confidence):\n", readable, existing)
+	printEstimate("Painted pixels", result.Painted)
+	printEstimate("Painted tiles", result.PaintedTiles)
+	printEstimate("Premium pixels", result.Premium)
+
+	outputPath := out.Path(op.Name(), "json")
+	fmt.Fprintf(os.Stderr, "Saving estimate %s to disk...", outputPath)
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(outputPath, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Data saved successfully!\n")
+
+	if samplePreview {
+		return op.savePreview(out, grid, ok)
+	}
+	return nil
+}
+
+func printEstimate(what string, e sampleEstimate) {
+	fmt.Printf("  %s: %s (%s to %s)\n", what, formatCount(e.Estimate), formatCount(e.Low), formatCount(e.High))
+}
+
+// Sampled tiles as they are, every other tile that exists as its block's mean. Good enough to spot
+// a folder that's missing half the world, not much else
+func (op *estimateOperation) savePreview(out *Output, grid *Grid, ok []bool) error {
+	s := op.sample
+
+	means := make([]float64, len(s.strata))
+	for h, st := range s.strata {
+		var sum, n float64
+		for _, i := range st.picked {
+			if ok[i] {
+				sum += float64(binary.LittleEndian.Uint32(grid.AtTile(s.picked[i][0], s.picked[i][1])))
+				n++
+			}
+		}
+		if n > 0 {
+			means[h] = sum / n
+		}
+	}
+
+	ramp, err := newColourRamp(Options{}, tileSize*tileSize, "log", "hsl")
+	if err != nil {
+		return err
+	}
+
+	name := op.Name() + "-preview"
+	err = out.SaveRGB(name, grid.Width, grid.Height, func(x, y int) RGB {
+		tx, ty := grid.X+x, grid.Y+y
+		if i, sampled := s.position[[2]int{tx, ty}]; sampled && ok[i] {
+			return ramp.colour(float64(binary.LittleEndian.Uint32(grid.At(x, y))))
+		}
+		if !s.existing[x*grid.Height+y] {
+			return RGB{0, 0, 0}
+		}
+		return ramp.colour(means[s.stratumOf(tx, ty)])
+	})
+	if err != nil {
+		return err
+	}
+	return out.SavePNG(name+"-legend", ramp.legend("estimated pixels painted per tile", formatPixels))
+}
+
+// Samples get their own folder, they're nothing like the real outputs
+func sampleFolderName() string {
+	return "sample-" + strconv.FormatFloat(sampleRate, 'g', -1, 64)
+}
+
+func countExisting(s *tileSample) int {
+	n := 0
+	for _, st := range s.strata {
+		n += st.existing
+	}
+	return n
+}
+
+func (s *tileSample) coords() []tilearchive.Coord {
+	coords := make([]tilearchive.Coord, len(s.picked))
+	for i, p := range s.picked {
+		coords[i] = tilearchive.Coord{X: p[0], Y: p[1]}
+	}
+	return coords
+}
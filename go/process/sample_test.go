@@ -0,0 +1,153 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+// 3x2 blocks, the last column and row of them only partly inside the region. Block 0,0 is full,
+// 1,0 has a single tile, 2,1 has none and the rest are sparse
+func testSampleRegion() (Region, func(x, y int) bool) {
+	region := Region{X: 1000, Y: 2000, Width: 2*sampleBlock + 10, Height: sampleBlock + 7}
+	has := func(x, y int) bool {
+		bx, by := (x-region.X)/sampleBlock, (y-region.Y)/sampleBlock
+		switch {
+		case bx == 0 && by == 0:
+			return true
+		case bx == 1 && by == 0:
+			return x == region.X+sampleBlock+3 && y == region.Y+5
+		case bx == 2 && by == 1:
+			return false
+		}
+		return (x*7+y*3)%5 == 0
+	}
+	return region, has
+}
+
+func TestTileSampleDeterministic(t *testing.T) {
+	region, has := testSampleRegion()
+	a := newTileSample(5, region, 0.1, has)
+	b := newTileSample(5, region, 0.1, has)
+	if !slices.Equal(a.picked, b.picked) {
+		t.Error("the same folder and rate picked different tiles")
+	}
+	if c := newTileSample(6, region, 0.1, has); slices.Equal(a.picked, c.picked) {
+		t.Error("another folder picked exactly the same tiles")
+	}
+}
+
+func TestTileSampleStrata(t *testing.T) {
+	region, has := testSampleRegion()
+	for _, rate := range []float64{0, 0.01, 0.1, 0.5, 1} {
+		s := newTileSample(1, region, rate, has)
+
+		if len(s.strata) != 3*2 {
+			t.Fatalf("rate %v: %d strata, want 6", rate, len(s.strata))
+		}
+		if !slices.IsSortedFunc(s.picked, func(a, b [2]int) int {
+			if a[0] != b[0] {
+				return a[0] - b[0]
+			}
+			return a[1] - b[1]
+		}) {
+			t.Errorf("rate %v: picked tiles aren't in x then y order", rate)
+		}
+
+		existing := make([]int, len(s.strata))
+		for x := region.X; x < region.X+region.Width; x++ {
+			for y := region.Y; y < region.Y+region.Height; y++ {
+				if has(x, y) {
+					existing[s.stratumOf(x, y)]++
+				}
+			}
+		}
+
+		seen := 0
+		for h, st := range s.strata {
+			if st.existing != existing[h] {
+				t.Errorf("rate %v: stratum %d has %d existing, want %d", rate, h, st.existing, existing[h])
+			}
+			want := min(existing[h], max(2, int(math.Round(rate*float64(existing[h])))))
+			if len(st.picked) != want {
+				t.Errorf("rate %v: stratum %d picked %d of %d, want %d", rate, h, len(st.picked), existing[h], want)
+			}
+			for _, i := range st.picked {
+				p := s.picked[i]
+				if !has(p[0], p[1]) || s.stratumOf(p[0], p[1]) != h || s.position[p] != i {
+					t.Errorf("rate %v: stratum %d has tile %v that doesn't belong to it", rate, h, p)
+				}
+			}
+			seen += len(st.picked)
+		}
+		if seen != len(s.picked) {
+			t.Errorf("rate %v: strata hold %d tiles, %d were picked", rate, seen, len(s.picked))
+		}
+	}
+}
+
+func sampleWithStrata(strata ...sampleStratum) *estimateOperation {
+	s := &tileSample{strata: strata}
+	for _, st := range strata {
+		for range st.picked {
+			s.picked = append(s.picked, [2]int{})
+		}
+	}
+	return &estimateOperation{sample: s}
+}
+
+func allOK(n int) []bool {
+	ok := make([]bool, n)
+	for i := range ok {
+		ok[i] = true
+	}
+	return ok
+}
+
+func TestEstimateKnownValues(t *testing.T) {
+	// 10 tiles, 4 sampled at 1, 2, 3, 4: the mean is 2.5 so the total is 25, the sample variance 5/3
+	// and the estimate's variance 10² * (1 - 4/10) * 5/3 / 4 = 25
+	op := sampleWithStrata(sampleStratum{existing: 10, picked: []int{0, 1, 2, 3}})
+	got := op.estimate(allOK(4), func(i int) float64 { return float64(i + 1) })
+	want := sampleEstimate{Estimate: 25, Low: math.Round(25 - sampleZ*5), High: math.Round(25 + sampleZ*5)}
+	if got != want {
+		t.Errorf("estimate = %+v, want %+v", got, want)
+	}
+}
+
+func TestEstimateExact(t *testing.T) {
+	op := sampleWithStrata(
+		sampleStratum{existing: 100, picked: []int{0, 1, 2}},
+		sampleStratum{existing: 50, picked: []int{3, 4}},
+	)
+
+	// Every tile the same, there's no spread to be unsure about
+	got := op.estimate(allOK(5), func(int) float64 { return 7 })
+	if want := (sampleEstimate{Estimate: 150 * 7, Low: 150 * 7, High: 150 * 7}); got != want {
+		t.Errorf("equal tiles: estimate = %+v, want %+v", got, want)
+	}
+
+	// Every tile sampled, whatever they are the total is just their sum
+	op = sampleWithStrata(
+		sampleStratum{existing: 3, picked: []int{0, 1, 2}},
+		sampleStratum{existing: 2, picked: []int{3, 4}},
+	)
+	values := []float64{10, 0, 250, 3, 1000}
+	got = op.estimate(allOK(5), func(i int) float64 { return values[i] })
+	if want := (sampleEstimate{Estimate: 1263, Low: 1263, High: 1263}); got != want {
+		t.Errorf("full sample: estimate = %+v, want %+v", got, want)
+	}
+}
+
+// Unreadable tiles drop out of their block's mean, a block with none left drops out entirely
+func TestEstimateSkipsUnreadable(t *testing.T) {
+	op := sampleWithStrata(
+		sampleStratum{existing: 10, picked: []int{0, 1}},
+		sampleStratum{existing: 10, picked: []int{2, 3}},
+	)
+	ok := []bool{true, false, false, false}
+	got := op.estimate(ok, func(i int) float64 { return float64(i + 4) })
+	if want := (sampleEstimate{Estimate: 40, Low: 40, High: 40}); got != want {
+		t.Errorf("estimate = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"strconv"
+)
+
+// Binary layout of one folder's part of the series, all little endian:
+//
+//	"WPT2" | x uint32 | y uint32 | width uint32 | height uint32 | folder uint32 |
+//	width*height records, x-major (x*height+y)
+//
+// x/y are the first tile, so a region's file says where it is. Each record is painted uint32 |
+// changed uint32, changed being against the folder before. A range of folders gives one of these
+// per folder, together they're the whole series
+const seriesMagic = "WPT2"
+
+// What the series carries from one folder to the next, saved as <data>/<folder>-series.state so the
+// next folder, in this run or a later one, picks up from it instead of going back through history:
+//
+//	"WPTS" | width uint32 | height uint32 | start uint32 | last uint32 |
+//	first painted uint16 per tile | last changed uint16 per tile
+//
+// Both grids are x-major folder numbers, 0 = never
+const seriesStateMagic = "WPTS"
+
+func init() {
+	registerOperation(OperationSpec{
+		Key:    "t",
+		Params: append([]string{"f"}, rampParams...),
+		Usage:  "time series of painted and changed pixels, carried on from the folder before's state if there is one (f=csv or bin, default csv; maps take the count ramp params, default linear/viridis)",
+		New:    newSeriesOperation,
+	})
+}
+
+type seriesState struct {
+	width, height int
+	start, last   int
+	firstPainted  []uint16
+	lastChanged   []uint16
+}
+
+func newSeriesState(width, height, start int) *seriesState {
+	return &seriesState{
+		width:        width,
+		height:       height,
+		start:        start,
+		firstPainted: make([]uint16, width*height),
+		lastChanged:  make([]uint16, width*height),
+	}
+}
+
+func seriesStatePath(dir string, folder int) string {
+	return fmt.Sprintf("%s/%d-series.state", dir, folder)
+}
+
+func loadSeriesState(path string) (*seriesState, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReaderSize(file, 1<<20)
+
+	header := make([]byte, 20)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if string(header[0:4]) != seriesStateMagic {
+		return nil, fmt.Errorf("%s isn't a series state", path)
+	}
+
+	width := int(binary.LittleEndian.Uint32(header[4:8]))
+	height := int(binary.LittleEndian.Uint32(header[8:12]))
+	s := newSeriesState(width, height, int(binary.LittleEndian.Uint32(header[12:16])))
+	s.last = int(binary.LittleEndian.Uint32(header[16:20]))
+
+	if err := binary.Read(r, binary.LittleEndian, s.firstPainted); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := binary.Read(r, binary.LittleEndian, s.lastChanged); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *seriesState) save(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriterSize(file, 1<<20)
+
+	header := make([]byte, 0, 20)
+	header = append(header, seriesStateMagic...)
+	for _, v := range []int{s.width, s.height, s.start, s.last} {
+		header = binary.LittleEndian.AppendUint32(header, uint32(v))
+	}
+	w.Write(header)
+	binary.Write(w, binary.LittleEndian, s.firstPainted)
+	binary.Write(w, binary.LittleEndian, s.lastChanged)
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// The record is this folder's painted count and how many pixels changed since the folder before.
+// The op hangs on to the state between folders, so a range is one long series
+type seriesOperation struct {
+	format string
+	opts   Options
+	suffix string
+
+	state    *seriesState
+	previous previousFolder
+}
+
+func newSeriesOperation(o Options) (Operation, error) {
+	format := o.String("f", "csv")
+	if format != "csv" && format != "bin" {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+
+	// The real ramp is only made when saving, its range grows with every folder. This one just
+	// checks the params and works out the name
+	ramp, err := newColourRamp(o, math.MaxUint16, "linear", "viridis")
+	if err != nil {
+		return nil, err
+	}
+
+	return &seriesOperation{format: format, opts: o, suffix: ramp.suffix()}, nil
+}
+
+func (op *seriesOperation) Name() string {
+	return "series"
+}
+
+func (op *seriesOperation) RecordSize() int {
+	return 8
+}
+
+func (op *seriesOperation) ReadsFolder(folder int) int {
+	return folder - 1
+}
+
+func (op *seriesOperation) Prepare(out *Output) error {
+	if out.Folder > math.MaxUint16 {
+		return fmt.Errorf("folder %d is too big for the series state", out.Folder)
+	}
+
+	// The state is only for Encode, which workers never get to
+	if out.remote {
+		return op.previous.follow(out.Folder, out.continuing)
+	}
+
+	continuing := op.state != nil && op.state.last == out.Folder-1
+	if !continuing {
+		s, err := loadSeriesState(seriesStatePath(out.Dir, out.Folder-1))
+		switch {
+		case err == nil:
+			fmt.Printf("Carrying on the series from folder %d, started at %d\n", s.last, s.start)
+			op.state, continuing = s, true
+		case errors.Is(err, os.ErrNotExist):
+			op.state = nil
+		default:
+			return err
+		}
+	}
+
+	return op.previous.prepare(out.Folder, continuing, "series")
+}
+
+func (op *seriesOperation) ComparesPrevious() bool {
+	return op.previous.available
+}
+
+func (op *seriesOperation) Process(tile *Tile, rec []byte) error {
+	binary.LittleEndian.PutUint32(rec[0:4], countTile(tile))
+	return op.ProcessEmpty(tile, rec)
+}
+
+// Same painted count as last time, and nothing changed
+func (op *seriesOperation) ProcessUnchanged(tile *Tile, prev, rec []byte) bool {
+	copy(rec[0:4], prev[0:4])
+	clear(rec[4:8])
+	return true
+}
+
+func (op *seriesOperation) ProcessEmpty(tile *Tile, rec []byte) error {
+	changed, err := op.previous.changed(tile)
+	binary.LittleEndian.PutUint32(rec[4:8], changed)
+	return err
+}
+
+func seriesFromRecord(rec []byte) (painted, changed uint32) {
+	return binary.LittleEndian.Uint32(rec[0:4]), binary.LittleEndian.Uint32(rec[4:8])
+}
+
+func (op *seriesOperation) Encode(out *Output, grid *Grid) error {
+	if op.state == nil || op.state.width != grid.Width || op.state.height != grid.Height {
+		op.state = newSeriesState(grid.Width, grid.Height, out.Folder)
+	}
+	s := op.state
+
+	folder := uint16(out.Folder)
+	for i := range s.firstPainted {
+		painted, changed := seriesFromRecord(grid.Data[i*8:])
+		if painted > 0 && s.firstPainted[i] == 0 {
+			s.firstPainted[i] = folder
+		}
+		if changed > 0 {
+			s.lastChanged[i] = folder
+		}
+	}
+	s.last = out.Folder
+
+	if err := op.saveSeries(out.Path("series", op.format), out.Folder, grid); err != nil {
+		return err
+	}
+	if err := s.save(seriesStatePath(out.Dir, out.Folder)); err != nil {
+		return err
+	}
+
+	ramp, err := op.folderRamp(out.Folder)
+	if err != nil {
+		return err
+	}
+
+	outputs := []struct {
+		name, title string
+		folders     []uint16
+	}{
+		{"series-first-painted", "first painted", s.firstPainted},
+		{"series-last-changed", "last changed", s.lastChanged},
+	}
+	for _, m := range outputs {
+		name := m.name + op.suffix
+		err := out.SaveRGB(name, grid.Width, grid.Height, func(x, y int) RGB {
+			return ramp.colour(float64(m.folders[x*grid.Height+y]))
+		})
+		if err != nil {
+			return err
+		}
+
+		title := fmt.Sprintf("%s, folders %d-%d", m.title, s.start, s.last)
+		err = out.SavePNG(name+"-legend", ramp.legend(title, func(v float64) string {
+			return fmt.Sprintf("folder %.0f", v)
+		}))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Spans the whole series so far unless min/max were given, 0 (never) stays black
+func (op *seriesOperation) folderRamp(folder int) (*colourRamp, error) {
+	o := Options{Flags: op.opts.Flags, Params: maps.Clone(op.opts.Params)}
+	if _, ok := o.Params["min"]; !ok {
+		o.Params["min"] = strconv.Itoa(op.state.start - 1)
+	}
+	return newColourRamp(o, folder, "linear", "viridis")
+}
+
+// csv only lists tiles that are painted or changed, bin is the whole grid
+func (op *seriesOperation) saveSeries(outputPath string, folder int, grid *Grid) error {
+	if op.format == "csv" {
+		return saveTileCSV(outputPath, "x,y,painted,changed", grid, func(x, y int, rec []byte) string {
+			painted, changed := seriesFromRecord(rec)
+			if painted == 0 && changed == 0 {
+				return ""
+			}
+			return fmt.Sprintf("%d,%d", painted, changed)
+		})
+	}
+
+	fmt.Fprintf(os.Stderr, "Saving series %s to disk...", outputPath)
+
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriterSize(file, 1<<20)
+
+	header := make([]byte, 0, 24)
+	header = append(header, seriesMagic...)
+	for _, v := range []int{grid.X, grid.Y, grid.Width, grid.Height, folder} {
+		header = binary.LittleEndian.AppendUint32(header, uint32(v))
+	}
+	w.Write(header)
+	w.Write(grid.Data)
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Data saved successfully!\n")
+	return nil
+}
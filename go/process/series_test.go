@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestSeriesStateRoundTrip(t *testing.T) {
+	s := newSeriesState(3, 2, 4)
+	s.last = 9
+	copy(s.firstPainted, []uint16{0, 4, 5, 0, 9, 4})
+	copy(s.lastChanged, []uint16{0, 8, 9, 0, 9, 0})
+
+	path := seriesStatePath(t.TempDir(), 9)
+	if err := s.save(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadSeriesState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.width != 3 || got.height != 2 || got.start != 4 || got.last != 9 {
+		t.Errorf("header = %dx%d, folders %d-%d", got.width, got.height, got.start, got.last)
+	}
+	if !slices.Equal(got.firstPainted, s.firstPainted) || !slices.Equal(got.lastChanged, s.lastChanged) {
+		t.Errorf("grids = %v %v, want %v %v", got.firstPainted, got.lastChanged, s.firstPainted, s.lastChanged)
+	}
+
+	// Cut off halfway through the grids
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, data[:len(data)-3], 0o644)
+	if _, err := loadSeriesState(path); err == nil {
+		t.Error("loaded a truncated state")
+	}
+}
+
+// Three folders in a row through the same op, the way a range goes
+func TestSeriesEncode(t *testing.T) {
+	op, err := parseOperation("t:f=bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	series := op.(*seriesOperation)
+	dir := t.TempDir()
+	region := Region{X: 100, Y: 50, Width: 2, Height: 2}
+
+	// painted, changed per tile, x-major
+	folders := []struct {
+		folder  int
+		records [4][2]uint32
+	}{
+		{5, [4][2]uint32{{0, 0}, {10, 0}, {0, 0}, {0, 0}}},
+		{6, [4][2]uint32{{3, 3}, {10, 0}, {0, 0}, {0, 0}}},
+		{7, [4][2]uint32{{3, 0}, {12, 2}, {0, 0}, {0, 4}}},
+	}
+	for _, f := range folders {
+		grid := newGrid(region, op.RecordSize())
+		for i, r := range f.records {
+			binary.LittleEndian.PutUint32(grid.Data[i*8:], r[0])
+			binary.LittleEndian.PutUint32(grid.Data[i*8+4:], r[1])
+		}
+		if err := op.Encode(&Output{Folder: f.folder, Dir: dir}, grid); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := loadSeriesState(seriesStatePath(dir, 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.start != 5 || s.last != 7 {
+		t.Errorf("folders %d-%d, want 5-7", s.start, s.last)
+	}
+	if want := []uint16{6, 5, 0, 0}; !slices.Equal(s.firstPainted, want) {
+		t.Errorf("first painted = %v, want %v", s.firstPainted, want)
+	}
+	if want := []uint16{6, 7, 0, 7}; !slices.Equal(s.lastChanged, want) {
+		t.Errorf("last changed = %v, want %v", s.lastChanged, want)
+	}
+	if series.state == nil || series.state.last != 7 {
+		t.Error("op didn't hang on to the state")
+	}
+
+	data, err := os.ReadFile((&Output{Folder: 7, Dir: dir}).Path("series", "bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[:4]) != seriesMagic {
+		t.Fatalf("magic = %q", data[:4])
+	}
+	for i, want := range []uint32{100, 50, 2, 2, 7} {
+		if got := binary.LittleEndian.Uint32(data[4+i*4:]); got != want {
+			t.Errorf("header field %d = %d, want %d", i, got, want)
+		}
+	}
+	if len(data) != 24+4*8 {
+		t.Errorf("file is %d bytes, want %d", len(data), 24+4*8)
+	}
+}
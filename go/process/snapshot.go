@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Where another folder's x/y.png tiles might be, tried in order. Set up in main
+var snapshotRoots func(folder int) []string
+
+// An extracted folder and which tiles it has, so empty tiles don't cost a failed open each.
+// columns holds the sorted y's of every x, a map of every tile would be hundreds of MB
+type snapshot struct {
+	root    string
+	columns map[int][]int32
+}
+
+func (s *snapshot) has(x, y int) bool {
+	_, found := slices.BinarySearch(s.columns[x], int32(y))
+	return found
+}
+
+// Walking through a range only ever needs the last couple of folders
+const snapshotsKept = 4
+
+var (
+	snapshotMu    sync.Mutex
+	snapshotFound = map[int]*snapshot{}
+)
+
+// Works out which of snapshotRoots a folder is actually in and lists it, once per folder
+func openSnapshot(folder int) (*snapshot, error) {
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+
+	if s, ok := snapshotFound[folder]; ok {
+		return s, nil
+	}
+
+	roots := snapshotRoots(folder)
+	for _, root := range roots {
+		if !exists(root) {
+			continue
+		}
+
+		s := &snapshot{root: root, columns: make(map[int][]int32)}
+		columns, err := os.ReadDir(root)
+		if err != nil {
+			return nil, err
+		}
+		for _, column := range columns {
+			x, err := strconv.Atoi(column.Name())
+			if err != nil || !column.IsDir() {
+				continue
+			}
+			files, err := os.ReadDir(fmt.Sprintf("%s/%d", root, x))
+			if err != nil {
+				return nil, err
+			}
+			ys := make([]int32, 0, len(files))
+			for _, f := range files {
+				if y, err := strconv.Atoi(strings.TrimSuffix(f.Name(), ".png")); err == nil {
+					ys = append(ys, int32(y))
+				}
+			}
+			slices.Sort(ys)
+			s.columns[x] = ys
+		}
+
+		// Anyone still holding an old one can keep using it, it just won't be handed out again
+		if len(snapshotFound) >= snapshotsKept {
+			delete(snapshotFound, slices.Min(slices.Collect(maps.Keys(snapshotFound))))
+		}
+		snapshotFound[folder] = s
+		return s, nil
+	}
+	return nil, fmt.Errorf("folder %d isn't extracted, looked in %v", folder, roots)
+}
+
+// The same tile in another folder, nil if it's empty there. Reading straight out of a solid archive
+// one tile at a time would be far too slow, so the folder has to be extracted
+func loadSnapshotTile(folder, x, y int) (*Tile, error) {
+	s, err := openSnapshot(folder)
+	if err != nil {
+		return nil, err
+	}
+	if !s.has(x, y) {
+		return nil, nil
+	}
+
+	tile, err := tileFromFile(fmt.Sprintf("%s/%d/%d.png", s.root, x, y))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("folder %d: %w", folder, err)
+	}
+
+	if tile.Width != tileSize || tile.Height != tileSize {
+		return nil, fmt.Errorf("folder %d: tile is %dx%d, expected %dx%d", folder, tile.Width, tile.Height, tileSize, tileSize)
+	}
+	tile.Folder, tile.X, tile.Y = folder, x, y
+	return tile, nil
+}
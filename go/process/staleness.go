@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+)
+
+// The staleness grid, saved as <data>/<folder>-staleness.state after every folder. The next folder
+// only needs this and its own tiles, never the folder before or the whole history:
+//
+//	"WPSH" | width uint32 | height uint32 | folder uint32 | uint16 per tile | uint64 per tile
+//
+// Both grids are x-major (x*height+y). The first is how many folders ago the tile last changed,
+// stalenessNever if it's never been painted, the second the hashTile of its file, 0 if it was empty.
+// A tile counts as changed when its file does, same as -i goes by
+const (
+	stalenessMagic = "WPSH"
+	stalenessNever = math.MaxUint16
+)
+
+func init() {
+	registerOperation(OperationSpec{
+		Key:    "g",
+		Params: rampParams,
+		Usage:  "staleness, folders since each tile last changed, carried on from the folder before's state if there is one (takes the count ramp params, default linear/viridis)",
+		New:    newStalenessOperation,
+	})
+}
+
+type stalenessState struct {
+	width, height int
+	folder        int
+	stale         []uint16
+	hashes        []uint64
+}
+
+func newStalenessState(width, height int) *stalenessState {
+	s := &stalenessState{width: width, height: height, stale: make([]uint16, width*height), hashes: make([]uint64, width*height)}
+	for i := range s.stale {
+		s.stale[i] = stalenessNever
+	}
+	return s
+}
+
+func stalenessStatePath(dir string, folder int) string {
+	return fmt.Sprintf("%s/%d-staleness.state", dir, folder)
+}
+
+func loadStalenessState(path string) (*stalenessState, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReaderSize(file, 1<<20)
+
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if string(header[0:4]) != stalenessMagic {
+		return nil, fmt.Errorf("%s isn't a staleness state", path)
+	}
+
+	s := &stalenessState{
+		width:  int(binary.LittleEndian.Uint32(header[4:8])),
+		height: int(binary.LittleEndian.Uint32(header[8:12])),
+		folder: int(binary.LittleEndian.Uint32(header[12:16])),
+	}
+	s.stale = make([]uint16, s.width*s.height)
+	if err := binary.Read(r, binary.LittleEndian, s.stale); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	s.hashes = make([]uint64, s.width*s.height)
+	if err := binary.Read(r, binary.LittleEndian, s.hashes); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *stalenessState) save(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriterSize(file, 1<<20)
+
+	header := make([]byte, 0, 16)
+	header = append(header, stalenessMagic...)
+	for _, v := range []int{s.width, s.height, s.folder} {
+		header = binary.LittleEndian.AppendUint32(header, uint32(v))
+	}
+	w.Write(header)
+	binary.Write(w, binary.LittleEndian, s.stale)
+	binary.Write(w, binary.LittleEndian, s.hashes)
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// The record is painted uint32 | hash uint64, an empty tile's is all zero
+type stalenessOperation struct {
+	pureOperation
+	opts   Options
+	suffix string
+
+	state *stalenessState
+}
+
+func newStalenessOperation(o Options) (Operation, error) {
+	// The real ramp is only made when saving, when the oldest tile is known
+	ramp, err := newColourRamp(o, stalenessNever, "linear", "viridis")
+	if err != nil {
+		return nil, err
+	}
+	return &stalenessOperation{opts: o, suffix: ramp.suffix()}, nil
+}
+
+func (op *stalenessOperation) Name() string {
+	return "staleness"
+}
+
+func (op *stalenessOperation) RecordSize() int {
+	return 12
+}
+
+func (op *stalenessOperation) NeedsHash() bool {
+	return true
+}
+
+func (op *stalenessOperation) Prepare(out *Output) error {
+	if op.state != nil && op.state.folder == out.Folder-1 {
+		return nil
+	}
+
+	s, err := loadStalenessState(stalenessStatePath(out.Dir, out.Folder-1))
+	switch {
+	case err == nil:
+		fmt.Printf("Carrying on staleness from folder %d\n", s.folder)
+		op.state = s
+	case errors.Is(err, os.ErrNotExist):
+		fmt.Printf("Starting a new staleness map at folder %d, no changes are counted for it\n", out.Folder)
+		op.state = nil
+	default:
+		return err
+	}
+	return nil
+}
+
+func (op *stalenessOperation) Process(tile *Tile, rec []byte) error {
+	binary.LittleEndian.PutUint32(rec[0:4], countTile(tile))
+	binary.LittleEndian.PutUint64(rec[4:12], tile.Hash)
+	return nil
+}
+
+func (op *stalenessOperation) Encode(out *Output, grid *Grid) error {
+	if op.state == nil || op.state.width != grid.Width || op.state.height != grid.Height {
+		op.state = newStalenessState(grid.Width, grid.Height)
+	}
+	s := op.state
+
+	// A tile turning up painted for the first time counts as a change, even with nothing to compare
+	// against. Otherwise it's one folder older, stopping just short of never. A bad tile keeps its
+	// old hash and just gets older, whatever happened to it shows up once it can be read again
+	oldest := uint16(1)
+	for i, stale := range s.stale {
+		rec := grid.Data[i*12:]
+		painted, hash := binary.LittleEndian.Uint32(rec[0:4]), binary.LittleEndian.Uint64(rec[4:12])
+		bad := out.report != nil && out.report.problem(i/grid.Height, i%grid.Height) != tileOK
+		changed := !bad && hash != s.hashes[i]
+		switch {
+		case changed || (painted > 0 && stale == stalenessNever):
+			stale = 0
+		case stale < stalenessNever-1:
+			stale++
+		}
+		s.stale[i] = stale
+		if !bad {
+			s.hashes[i] = hash
+		}
+
+		if stale != stalenessNever {
+			oldest = max(oldest, stale)
+		}
+	}
+	s.folder = out.Folder
+
+	if err := s.save(stalenessStatePath(out.Dir, out.Folder)); err != nil {
+		return err
+	}
+
+	ramp, err := newColourRamp(op.opts, int(oldest), "linear", "viridis")
+	if err != nil {
+		return err
+	}
+	if ramp.scale == "quantile" {
+		ramp.fit(slices.DeleteFunc(stalenessCounts(s.stale), func(c uint32) bool { return c == stalenessNever }))
+	}
+
+	// 0 is a real value here (changed this folder), so only never painted tiles are black
+	name := op.Name() + op.suffix
+	err = out.SaveRGB(name, grid.Width, grid.Height, func(x, y int) RGB {
+		stale := s.stale[x*grid.Height+y]
+		if stale == stalenessNever {
+			return RGB{0, 0, 0}
+		}
+		return ramp.colourOf(ramp.value(float64(stale)))
+	})
+	if err != nil {
+		return err
+	}
+
+	return out.SavePNG(name+"-legend", ramp.legend("folders since last change", func(v float64) string {
+		return strconv.FormatFloat(math.Round(v), 'f', 0, 64)
+	}))
+}
+
+func stalenessCounts(stale []uint16) []uint32 {
+	counts := make([]uint32, len(stale))
+	for i, s := range stale {
+		counts[i] = uint32(s)
+	}
+	return counts
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+)
+
+// One folder's worth of staleness records, painted and hash per tile
+func stalenessGrid(region Region, records [][2]uint64) *Grid {
+	grid := newGrid(region, 12)
+	for i, r := range records {
+		binary.LittleEndian.PutUint32(grid.Data[i*12:], uint32(r[0]))
+		binary.LittleEndian.PutUint64(grid.Data[i*12+4:], r[1])
+	}
+	return grid
+}
+
+func TestStalenessAcrossFolders(t *testing.T) {
+	op, err := parseOperation("g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	region := Region{Width: 5, Height: 1}
+
+	// Tile 0 never changes, 1 changes in folder 3, 2 is wiped in folder 3, 3 is never painted and
+	// 4 can't be read in folder 3 but is back as it was in 4
+	folders := []struct {
+		folder  int
+		records [][2]uint64
+		bad     int // tile, -1 = none
+		want    []uint16
+	}{
+		{2, [][2]uint64{{5, 11}, {5, 22}, {5, 33}, {0, 0}, {5, 55}}, -1, []uint16{0, 0, 0, stalenessNever, 0}},
+		{3, [][2]uint64{{5, 11}, {6, 23}, {0, 0}, {0, 0}, {0, 0}}, 4, []uint16{1, 0, 0, stalenessNever, 1}},
+		{4, [][2]uint64{{5, 11}, {6, 23}, {0, 0}, {0, 0}, {5, 55}}, -1, []uint16{2, 1, 1, stalenessNever, 2}},
+	}
+	for _, f := range folders {
+		out := &Output{Folder: f.folder, Dir: dir, report: newTileReport(region)}
+		if f.bad >= 0 {
+			out.report.status.At(f.bad, 0)[0] = byte(tileUndecodable)
+		}
+		if err := op.(Preparer).Prepare(out); err != nil {
+			t.Fatal(err)
+		}
+		if err := op.Encode(out, stalenessGrid(region, f.records)); err != nil {
+			t.Fatal(err)
+		}
+
+		// Straight from the state file, so a fresh op for the next folder would see the same
+		s, err := loadStalenessState(stalenessStatePath(dir, f.folder))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(s.stale, f.want) {
+			t.Errorf("folder %d: %v, want %v", f.folder, s.stale, f.want)
+		}
+	}
+
+	// The bad tile kept its hash from folder 2
+	s, _ := loadStalenessState(stalenessStatePath(dir, 3))
+	if s.hashes[4] != 55 {
+		t.Errorf("bad tile's hash = %d, want 55", s.hashes[4])
+	}
+}
+
+func TestStalenessRecord(t *testing.T) {
+	op, _ := parseOperation("g")
+	tile := testTile(t, testPaletted(2, 2, []RGB{red}, []uint8{1, 0, 1, 1}), false)
+	tile.Hash = 0xdeadbeef
+
+	rec := make([]byte, op.RecordSize())
+	if err := op.Process(tile, rec); err != nil {
+		t.Fatal(err)
+	}
+	if painted, hash := binary.LittleEndian.Uint32(rec), binary.LittleEndian.Uint64(rec[4:]); painted != 3 || hash != 0xdeadbeef {
+		t.Errorf("record = %d painted, hash %x", painted, hash)
+	}
+	if h, ok := op.(TileHasher); !ok || !h.NeedsHash() {
+		t.Error("staleness doesn't ask for hashes")
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+)
+
+// The exact numbers behind a tile, since the images only ever show a colour
+type TileStats struct {
+	Exists  bool
+	Painted uint32
+	Unique  uint16 // stops at 65535, only an RGBA tile can have more colours than that
+	Mode    RGB
+	Average RGB
+}
+
+// Binary grid layout, all little endian:
+//
+//	"WPS2" | x uint32 | y uint32 | width uint32 | height uint32 | width*height records, x-major (x*height+y)
+//
+// x/y is the region's top left tile, 0/0 for the whole world, so a region's file knows where it is.
+//
+// Each record is statsRecordSize bytes:
+//
+//	painted uint32 | unique uint16 | flags uint8 (bit 0 = tile exists) | mode RGB | average RGB
+const (
+	statsMagic      = "WPS2"
+	statsRecordSize = 13
+)
+
+var dataFormats = map[string]bool{"csv": true, "jsonl": true, "bin": true}
+
+func init() {
+	registerOperation(OperationSpec{
+		Key:    "s",
+		Params: []string{"f"},
+		Usage:  "raw stats (f=csv, jsonl or bin, default csv)",
+		New: func(o Options) (Operation, error) {
+			format := o.String("f", "csv")
+			if !dataFormats[format] {
+				return nil, fmt.Errorf("unknown data format %q", format)
+			}
+			return statsOperation{format: format}, nil
+		},
+	})
+}
+
+// The record is exactly what ends up in the binary grid
+type statsOperation struct {
+	pureOperation
+	format string
+}
+
+func (statsOperation) Name() string {
+	return "stats"
+}
+
+func (statsOperation) RecordSize() int {
+	return statsRecordSize
+}
+
+func (statsOperation) Process(tile *Tile, rec []byte) error {
+	if tile.paletted() {
+		statsPaletted(tile).put(rec)
+	} else {
+		statsRGBA(tile.Pix, tile.Width, tile.Height).put(rec)
+	}
+	return nil
+}
+
+func (op statsOperation) Encode(out *Output, grid *Grid) error {
+	return saveStats(out.Path(op.Name(), op.format), op.format, grid)
+}
+
+func (s TileStats) put(rec []byte) {
+	binary.LittleEndian.PutUint32(rec[0:4], s.Painted)
+	binary.LittleEndian.PutUint16(rec[4:6], s.Unique)
+	rec[6] = 0
+	if s.Exists {
+		rec[6] = 1
+	}
+	s.Mode.put(rec[7:10])
+	s.Average.put(rec[10:13])
+}
+
+func statsFromRecord(rec []byte) TileStats {
+	return TileStats{
+		Exists:  rec[6]&1 != 0,
+		Painted: binary.LittleEndian.Uint32(rec[0:4]),
+		Unique:  binary.LittleEndian.Uint16(rec[4:6]),
+		Mode:    rgbFromRecord(rec[7:10]),
+		Average: rgbFromRecord(rec[10:13]),
+	}
+}
+
+// One walk over the pixels for everything, so asking for data costs no extra decode
+// Mode here includes black and white, it's the raw most common colour, not the "interesting" one
+func statsRGBA(pixels []uint8, width, height int) TileStats {
+	counts := make(map[uint32]int, 64)
+	var r, g, b, painted uint64
+
+	for i := 0; i < width*height*4; i += 4 {
+		if pixels[i+3] == 0 {
+			continue
+		}
+
+		r += uint64(pixels[i])
+		g += uint64(pixels[i+1])
+		b += uint64(pixels[i+2])
+		painted++
+
+		counts[uint32(pixels[i])<<16|uint32(pixels[i+1])<<8|uint32(pixels[i+2])]++
+	}
+
+	return statsOf(counts, r, g, b, painted)
+}
+
+func statsPaletted(tile *Tile) TileStats {
+	counts := make(map[uint32]int, 64)
+	r, g, b, painted := paletteColourCounts(tile, counts)
+	return statsOf(counts, r, g, b, painted)
+}
+
+func statsOf(counts map[uint32]int, r, g, b, painted uint64) TileStats {
+	stats := TileStats{Exists: true, Painted: uint32(painted), Unique: uint16(min(len(counts), math.MaxUint16))}
+	if painted == 0 {
+		return stats
+	}
+
+	best, _ := mostCommon(counts)
+	stats.Mode = unpackRGB(best.packed)
+
+	stats.Average = RGB{R: uint8(r / painted), G: uint8(g / painted), B: uint8(b / painted)}
+	return stats
+}
+
+func saveStats(outputPath, format string, grid *Grid) error {
+	fmt.Fprintf(os.Stderr, "Saving %s data %s to disk...", format, outputPath)
+
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriterSize(file, 1<<20)
+
+	switch format {
+	case "csv":
+		err = writeStatsCSV(w, grid)
+	case "jsonl":
+		err = writeStatsJSONL(w, grid)
+	case "bin":
+		err = writeStatsBinary(w, grid)
+	default:
+		err = fmt.Errorf("unknown data format %q", format)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Data saved successfully!\n")
+	return nil
+}
+
+// Text formats only list tiles that exist, listing 4 million empty rows helps nobody
+func writeStatsCSV(w *bufio.Writer, grid *Grid) error {
+	if _, err := w.WriteString("x,y,painted,unique,mode,average\n"); err != nil {
+		return err
+	}
+
+	var line []byte
+	for x := range grid.Width {
+		for y := range grid.Height {
+			s := statsFromRecord(grid.At(x, y))
+			if !s.Exists {
+				continue
+			}
+
+			line = line[:0]
+			line = strconv.AppendInt(line, int64(grid.X+x), 10)
+			line = append(line, ',')
+			line = strconv.AppendInt(line, int64(grid.Y+y), 10)
+			line = append(line, ',')
+			line = strconv.AppendUint(line, uint64(s.Painted), 10)
+			line = append(line, ',')
+			line = strconv.AppendUint(line, uint64(s.Unique), 10)
+			line = append(line, ',')
+			line = append(line, s.Mode.hex()...)
+			line = append(line, ',')
+			line = append(line, s.Average.hex()...)
+			line = append(line, '\n')
+
+			if _, err := w.Write(line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeStatsJSONL(w *bufio.Writer, grid *Grid) error {
+	type record struct {
+		X       int    `json:"x"`
+		Y       int    `json:"y"`
+		Painted uint32 `json:"painted"`
+		Unique  uint16 `json:"unique"`
+		Mode    string `json:"mode"`
+		Average string `json:"average"`
+	}
+
+	enc := json.NewEncoder(w)
+	for x := range grid.Width {
+		for y := range grid.Height {
+			s := statsFromRecord(grid.At(x, y))
+			if !s.Exists {
+				continue
+			}
+
+			err := enc.Encode(record{X: grid.X + x, Y: grid.Y + y, Painted: s.Painted, Unique: s.Unique, Mode: s.Mode.hex(), Average: s.Average.hex()})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeStatsBinary(w *bufio.Writer, grid *Grid) error {
+	header := make([]byte, 0, 20)
+	header = append(header, statsMagic...)
+	for _, v := range []int{grid.X, grid.Y, grid.Width, grid.Height} {
+		header = binary.LittleEndian.AppendUint32(header, uint32(v))
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	// The grid is already in the right layout, so it just gets dumped as is
+	_, err := w.Write(grid.Data)
+	return err
+}
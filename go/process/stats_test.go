@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestWriteStatsBinaryHeader(t *testing.T) {
+	grid := newGrid(Region{X: 100, Y: 200, Width: 3, Height: 2}, statsRecordSize)
+	TileStats{Exists: true, Painted: 7}.put(grid.AtTile(101, 201))
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	if err := writeStatsBinary(w, grid); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+	data := buf.Bytes()
+
+	if got := string(data[0:4]); got != statsMagic {
+		t.Fatalf("magic = %q, want %q", got, statsMagic)
+	}
+	for i, want := range []uint32{100, 200, 3, 2} {
+		if got := binary.LittleEndian.Uint32(data[4+i*4:]); got != want {
+			t.Errorf("header field %d = %d, want %d", i, got, want)
+		}
+	}
+	if len(data) != 20+len(grid.Data) {
+		t.Fatalf("file is %d bytes, want %d", len(data), 20+len(grid.Data))
+	}
+	if s := statsFromRecord(data[20+(1*2+1)*statsRecordSize:]); !s.Exists || s.Painted != 7 {
+		t.Errorf("record for 101,201 = %+v", s)
+	}
+}
+
+func TestStatsUniqueClamps(t *testing.T) {
+	tests := []struct {
+		colours int
+		want    uint16
+	}{
+		{0, 0},
+		{63, 63},
+		{math.MaxUint16, math.MaxUint16},
+		{math.MaxUint16 + 1, math.MaxUint16},
+		{200_000, math.MaxUint16},
+	}
+	for _, tt := range tests {
+		counts := make(map[uint32]int, tt.colours)
+		for i := range tt.colours {
+			counts[uint32(i)] = 1
+		}
+		if got := statsOf(counts, 0, 0, 0, uint64(tt.colours)).Unique; got != tt.want {
+			t.Errorf("%d colours: unique = %d, want %d", tt.colours, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"components"
+)
+
+func init() {
+	registerOperation(OperationSpec{
+		Key:    "l",
+		Params: append([]string{"d", "m"}, rampShapeParams...),
+		Usage:  "structure, connected blobs of painted pixels per tile: how many, the largest one's size and how much of its bounding box it fills, as maps and a csv (d=grow the pixels by this many first so near misses join up, default 0, sizes then count the grown pixels; m=ignore blobs smaller than this, default 10; maps take the ramp params except min/max)",
+		New: func(o Options) (Operation, error) {
+			dilate, err := o.Int("d", 0)
+			if err != nil {
+				return nil, err
+			}
+			if dilate < 0 || dilate > 16 {
+				return nil, fmt.Errorf("d (%d) has to be between 0 and 16", dilate)
+			}
+			minSize, err := o.Int("m", 10)
+			if err != nil {
+				return nil, err
+			}
+			if minSize < 1 {
+				return nil, fmt.Errorf("m (%d) has to be at least 1", minSize)
+			}
+
+			op := structureOperation{dilate: dilate, minSize: minSize}
+			ramps := []struct {
+				dst   **colourRamp
+				max   int
+				scale string
+				cmap  string
+			}{
+				{&op.countRamp, 1000, "log", "viridis"},
+				{&op.largestRamp, tileSize * tileSize, "log", "hsl"},
+				{&op.fillRamp, shareScale, "linear", "magma"},
+			}
+			for _, r := range ramps {
+				if *r.dst, err = newColourRamp(o, r.max, r.scale, r.cmap); err != nil {
+					return nil, err
+				}
+			}
+			return op, nil
+		},
+	})
+}
+
+// The record is the painted count, how many blobs there are, the largest one's size and its fill
+// (size over bounding box area) in basis points. One big artwork is a single large blob, scribbles
+// are lots of small ones. Same thing smart-crop uses to decide what to crop
+type structureOperation struct {
+	pureOperation
+	dilate, minSize int
+
+	countRamp, largestRamp, fillRamp *colourRamp
+}
+
+type tileStructure struct {
+	painted, components, largest, fill uint32
+}
+
+func (op structureOperation) Name() string {
+	name := "structure"
+	if op.dilate > 0 {
+		name += fmt.Sprintf("-d%d", op.dilate)
+	}
+	if op.minSize != 10 {
+		name += fmt.Sprintf("-m%d", op.minSize)
+	}
+	return name
+}
+
+func (structureOperation) RecordSize() int {
+	return 16
+}
+
+func (op structureOperation) Process(tile *Tile, rec []byte) error {
+	w, h, mask, solid := makeMaskTile(tile)
+	s := tileStructure{painted: uint32(solid)}
+
+	if solid >= op.minSize {
+		var largest components.Component
+		for _, c := range components.Find8(w, h, components.Dilate(w, h, mask, op.dilate)) {
+			if c.Count < op.minSize {
+				continue
+			}
+			s.components++
+			if c.Count > largest.Count {
+				largest = c
+			}
+		}
+		if s.components > 0 {
+			area := (largest.MaxX - largest.MinX + 1) * (largest.MaxY - largest.MinY + 1)
+			s.largest = uint32(largest.Count)
+			s.fill = uint32(largest.Count * shareScale / area)
+		}
+	}
+
+	binary.LittleEndian.PutUint32(rec[0:4], s.painted)
+	binary.LittleEndian.PutUint32(rec[4:8], s.components)
+	binary.LittleEndian.PutUint32(rec[8:12], s.largest)
+	binary.LittleEndian.PutUint32(rec[12:16], s.fill)
+	return nil
+}
+
+func structureFromRecord(rec []byte) tileStructure {
+	return tileStructure{
+		painted:    binary.LittleEndian.Uint32(rec[0:4]),
+		components: binary.LittleEndian.Uint32(rec[4:8]),
+		largest:    binary.LittleEndian.Uint32(rec[8:12]),
+		fill:       binary.LittleEndian.Uint32(rec[12:16]),
+	}
+}
+
+func (op structureOperation) Encode(out *Output, grid *Grid) error {
+	if err := op.saveCSV(out.Path(op.Name(), "csv"), grid); err != nil {
+		return err
+	}
+
+	return saveTileMaps(out, grid, []tileMap{
+		{op.Name() + "-components" + op.countRamp.suffix(), op.countRamp, func(rec []byte) uint32 { return structureFromRecord(rec).components }, "blobs per tile", formatCount},
+		{op.Name() + "-largest" + op.largestRamp.suffix(), op.largestRamp, func(rec []byte) uint32 { return structureFromRecord(rec).largest }, "largest blob per tile", formatPixels},
+		{op.Name() + "-fill" + op.fillRamp.suffix(), op.fillRamp, func(rec []byte) uint32 { return structureFromRecord(rec).fill }, "how much of its box the largest blob fills", func(v float64) string {
+			return fmt.Sprintf("%.1f%%", v*100/shareScale)
+		}},
+	})
+}
+
+// One row per tile with at least one blob: x,y,painted,components,largest,fill
+func (op structureOperation) saveCSV(outputPath string, grid *Grid) error {
+	return saveTileCSV(outputPath, "x,y,painted,components,largest,fill", grid, func(x, y int, rec []byte) string {
+		s := structureFromRecord(rec)
+		if s.components == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%d,%d,%d,%.4f", s.painted, s.components, s.largest, float64(s.fill)/shareScale)
+	})
+}
+
+// components.MaskNRGBA for either kind of tile
+func makeMaskTile(tile *Tile) (w, h int, mask []byte, solidCount int) {
+	w, h = tile.Width, tile.Height
+	mask = make([]byte, w*h)
+
+	if tile.paletted() {
+		var solid [256]byte
+		for i, e := range tile.Palette {
+			if e.painted {
+				solid[i] = 1
+			}
+		}
+		for p, idx := range tile.Index {
+			mask[p] = solid[idx]
+			solidCount += int(solid[idx])
+		}
+		return
+	}
+
+	for p := range w * h {
+		if int(tile.Pix[p*4+3]) >= components.AlphaThreshold {
+			mask[p] = 1
+			solidCount++
+		}
+	}
+	return
+}
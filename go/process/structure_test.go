@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestStructureProcess(t *testing.T) {
+	// A hollow 5x5 square (16 pixels, fills 64% of its box), a 5 pixel diagonal that only holds
+	// together with 8-connectivity (20%) and a lone pixel
+	const w, h = 12, 8
+	shapes := make([]uint8, w*h)
+	for i := range 5 {
+		shapes[0*w+i], shapes[4*w+i], shapes[i*w+0], shapes[i*w+4] = 1, 1, 1, 1
+		shapes[i*w+6+i] = 1
+	}
+	shapes[7*w+11] = 1
+
+	dot := make([]uint8, w*h)
+	dot[4*w+5] = 1
+
+	tests := []struct {
+		name   string
+		op     string
+		pixels []uint8
+		want   tileStructure
+	}{
+		{"every blob", "l:m=1", shapes, tileStructure{painted: 22, components: 3, largest: 16, fill: 6400}},
+		{"small ones ignored", "l:m=5", shapes, tileStructure{painted: 22, components: 2, largest: 16, fill: 6400}},
+		{"only the square", "l", shapes, tileStructure{painted: 22, components: 1, largest: 16, fill: 6400}},
+		{"too few painted", "l:m=30", shapes, tileStructure{painted: 22}},
+		{"grown dot", "l:m=1:d=1", dot, tileStructure{painted: 1, components: 1, largest: 9, fill: shareScale}},
+	}
+
+	for _, tt := range tests {
+		op, err := parseOperation(tt.op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img := testPaletted(w, h, []RGB{red}, tt.pixels)
+		for _, kind := range tileKinds {
+			rec := make([]byte, op.RecordSize())
+			if err := op.Process(testTile(t, img, kind.rgba), rec); err != nil {
+				t.Fatal(err)
+			}
+			if got := structureFromRecord(rec); got != tt.want {
+				t.Errorf("%s %s: got %+v, want %+v", tt.name, kind.name, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestStructureRampParams(t *testing.T) {
+	op, err := parseOperation("l:cmap=hsl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := op.(structureOperation)
+	for _, r := range []*colourRamp{s.countRamp, s.largestRamp, s.fillRamp} {
+		if r.cmap != "hsl" {
+			t.Errorf("ramp has cmap %s, want hsl", r.cmap)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+func init() {
+	registerOperation(OperationSpec{
+		Key:    "u",
+		Flags:  "c",
+		Params: []string{"n"},
+		Usage:  "summary of the whole folder as json plus a line in summary-trend.jsonl (n=how many of the busiest tiles to list, default 20; 16 bytes a tile in memory, colours only say how many tiles use them unless flag c counts their pixels too, which makes it 208 bytes a tile)",
+		New: func(o Options) (Operation, error) {
+			n, err := o.Int("n", 20)
+			if err != nil {
+				return nil, err
+			}
+			if n < 0 {
+				return nil, fmt.Errorf("n (%d) can't be negative", n)
+			}
+			return summaryOperation{n: n, pixels: o.Has('c')}, nil
+		},
+	})
+}
+
+const trendFile = "summary-trend.jsonl"
+
+// The record is the painted and premium counts, then a bit per palette slot the tile uses (see
+// countByPalette, all 64 fit in a uint64). With pixels, each slot's count follows as 3 bytes, a tile
+// is never more than 1M
+type summaryOperation struct {
+	pureOperation
+	n      int
+	pixels bool
+}
+
+func (summaryOperation) Name() string {
+	return "summary"
+}
+
+func (op summaryOperation) RecordSize() int {
+	if op.pixels {
+		return 16 + paletteSlots*3
+	}
+	return 16
+}
+
+func (op summaryOperation) Process(tile *Tile, rec []byte) error {
+	counts := make([]uint32, paletteSlots)
+	countByPalette(tile, counts)
+
+	var painted, premium uint32
+	var used uint64
+	for i, n := range counts {
+		if n == 0 {
+			continue
+		}
+		painted += n
+		if i < len(wplacePalette) && wplacePalette[i].Premium {
+			premium += n
+		}
+		used |= 1 << i
+		if op.pixels {
+			putUint24(rec[16+i*3:], n)
+		}
+	}
+	binary.LittleEndian.PutUint32(rec[0:4], painted)
+	binary.LittleEndian.PutUint32(rec[4:8], premium)
+	binary.LittleEndian.PutUint64(rec[8:16], used)
+	return nil
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+type colourTotal struct {
+	Name   string  `json:"name"`
+	Hex    string  `json:"hex,omitempty"`
+	Tiles  int     `json:"tiles"`
+	Pixels *uint64 `json:"pixels,omitempty"` // only with flag c
+}
+
+type busyTile struct {
+	X       int    `json:"x"`
+	Y       int    `json:"y"`
+	Painted uint32 `json:"painted"`
+}
+
+// The trend file gets the same thing minus the busiest tiles, one line per folder
+type folderSummary struct {
+	Folder  int           `json:"folder"`
+	Region  string        `json:"region,omitempty"`
+	Painted uint64        `json:"painted"`
+	Premium uint64        `json:"premium"`
+	Tiles   int           `json:"tiles"`
+	Colours []colourTotal `json:"colours"`
+	Busiest []busyTile    `json:"busiest,omitempty"`
+}
+
+func (op summaryOperation) Encode(out *Output, grid *Grid) error {
+	s := op.summarise(out.Folder, grid)
+
+	outputPath := out.Path(op.Name(), "json")
+	fmt.Fprintf(os.Stderr, "Saving summary %s to disk...", outputPath)
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(outputPath, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Data saved successfully!\n")
+
+	s.Busiest = nil
+	return updateTrend(filepath.Join(out.Dir, trendFile), s)
+}
+
+func (op summaryOperation) summarise(folder int, grid *Grid) folderSummary {
+	s := folderSummary{Folder: folder}
+	if !grid.isWorld() {
+		s.Region = grid.Region.String()
+	}
+
+	tiles := make([]int, paletteSlots)
+	pixels := make([]uint64, paletteSlots)
+	var busiest []busyTile
+	for x := range grid.Width {
+		for y := range grid.Height {
+			rec := grid.At(x, y)
+			painted := binary.LittleEndian.Uint32(rec[0:4])
+			if painted == 0 {
+				continue
+			}
+
+			used := binary.LittleEndian.Uint64(rec[8:16])
+			for i := range paletteSlots {
+				if used&(1<<i) == 0 {
+					continue
+				}
+				tiles[i]++
+				if op.pixels {
+					pixels[i] += uint64(uint24(rec[16+i*3:]))
+				}
+			}
+
+			s.Painted += uint64(painted)
+			s.Premium += uint64(binary.LittleEndian.Uint32(rec[4:8]))
+			s.Tiles++
+			if op.n > 0 {
+				busiest = append(busiest, busyTile{X: grid.X + x, Y: grid.Y + y, Painted: painted})
+			}
+		}
+	}
+
+	// Every palette colour is always there so the trend lines all have the same shape,
+	// anything off palette only shows up if there is some
+	total := func(i int) colourTotal {
+		t := colourTotal{Tiles: tiles[i]}
+		if op.pixels {
+			t.Pixels = &pixels[i]
+		}
+		return t
+	}
+	for i, c := range wplacePalette {
+		t := total(i)
+		t.Name, t.Hex = c.Name, c.RGB.hex()
+		s.Colours = append(s.Colours, t)
+	}
+	if other := len(wplacePalette); tiles[other] > 0 {
+		t := total(other)
+		t.Name = "Other"
+		s.Colours = append(s.Colours, t)
+	}
+
+	// Grid order is already x then y, so a stable sort leaves ties in that order
+	slices.SortStableFunc(busiest, func(a, b busyTile) int {
+		return int(b.Painted) - int(a.Painted)
+	})
+	s.Busiest = busiest[:min(op.n, len(busiest))]
+	return s
+}
+
+// Rewrites the trend file with this folder's line in place of any earlier one for it, sorted by
+// folder, so running a folder again doesn't leave it in there twice
+func updateTrend(path string, s folderSummary) error {
+	fmt.Fprintf(os.Stderr, "Adding folder %d to %s...", s.Folder, path)
+
+	type trendLine struct {
+		folder int
+		line   []byte
+	}
+	var lines []trendLine
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var head struct {
+			Folder int `json:"folder"`
+		}
+		if err := json.Unmarshal(line, &head); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if head.Folder != s.Folder {
+			lines = append(lines, trendLine{head.Folder, line})
+		}
+	}
+
+	line, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	lines = append(lines, trendLine{s.Folder, line})
+	slices.SortStableFunc(lines, func(a, b trendLine) int {
+		return a.folder - b.folder
+	})
+
+	// Written next to it and moved over, a crash halfway shouldn't cost the whole history
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	for _, l := range lines {
+		w.Write(l.line)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	fmt.Printf("Data saved successfully!\n")
+	return nil
+}
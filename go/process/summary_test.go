@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSummarise(t *testing.T) {
+	premium := 0
+	for premium < len(wplacePalette) && !wplacePalette[premium].Premium {
+		premium++
+	}
+	gold := wplacePalette[premium].RGB
+
+	// Two tiles: 3 red + 1 premium, and 2 red + 1 off palette
+	first := testPaletted(2, 2, []RGB{red, gold}, []uint8{1, 1, 1, 2})
+	second := testPaletted(2, 2, []RGB{red, {1, 2, 3}}, []uint8{1, 0, 1, 2})
+	region := Region{X: 5, Y: 7, Width: 2, Height: 1}
+
+	for _, token := range []string{"u", "uc"} {
+		for _, kind := range tileKinds {
+			parsed, err := parseOperation(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			op := parsed.(summaryOperation)
+			grid := newGrid(region, op.RecordSize())
+			for x, img := range []*image.Paletted{first, second} {
+				if err := op.Process(testTile(t, img, kind.rgba), grid.At(x, 0)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			s := op.summarise(3, grid)
+			name := token + " " + kind.name
+			if s.Painted != 7 || s.Premium != 1 || s.Tiles != 2 || s.Region != region.String() {
+				t.Errorf("%s: painted %d, premium %d, tiles %d, region %q", name, s.Painted, s.Premium, s.Tiles, s.Region)
+			}
+			if want := []busyTile{{5, 7, 4}, {6, 7, 3}}; !slices.Equal(s.Busiest, want) {
+				t.Errorf("%s: busiest %v, want %v", name, s.Busiest, want)
+			}
+			if len(s.Colours) != len(wplacePalette)+1 {
+				t.Fatalf("%s: %d colours, want every palette colour and other", name, len(s.Colours))
+			}
+
+			want := map[string][2]int{
+				wplacePalette[6].Name:       {2, 5},
+				wplacePalette[premium].Name: {1, 1},
+				"Other":                     {1, 1},
+			}
+			for _, c := range s.Colours {
+				w := want[c.Name]
+				if c.Tiles != w[0] {
+					t.Errorf("%s: %s is in %d tiles, want %d", name, c.Name, c.Tiles, w[0])
+				}
+				switch {
+				case op.pixels && (c.Pixels == nil || *c.Pixels != uint64(w[1])):
+					t.Errorf("%s: %s has %v pixels, want %d", name, c.Name, c.Pixels, w[1])
+				case !op.pixels && c.Pixels != nil:
+					t.Errorf("%s: %s has pixels without c", name, c.Name)
+				}
+			}
+		}
+	}
+}
+
+func readTrend(t *testing.T, path string) []folderSummary {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []folderSummary
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		var s folderSummary
+		if err := json.Unmarshal([]byte(line), &s); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		lines = append(lines, s)
+	}
+	return lines
+}
+
+func TestUpdateTrend(t *testing.T) {
+	for _, existing := range []string{"missing", "empty"} {
+		path := filepath.Join(t.TempDir(), trendFile)
+		if existing == "empty" {
+			if err := os.WriteFile(path, nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Out of order, then folder 5 again with a different count
+		steps := []struct {
+			s    folderSummary
+			want []int
+		}{
+			{folderSummary{Folder: 5, Painted: 50}, []int{5}},
+			{folderSummary{Folder: 9, Painted: 90}, []int{5, 9}},
+			{folderSummary{Folder: 2, Painted: 20}, []int{2, 5, 9}},
+			{folderSummary{Folder: 5, Painted: 55}, []int{2, 5, 9}},
+		}
+		for _, step := range steps {
+			if err := updateTrend(path, step.s); err != nil {
+				t.Fatal(err)
+			}
+			var folders []int
+			for _, s := range readTrend(t, path) {
+				folders = append(folders, s.Folder)
+			}
+			if !slices.Equal(folders, step.want) {
+				t.Errorf("%s: after folder %d the trend has %v, want %v", existing, step.s.Folder, folders, step.want)
+			}
+		}
+
+		lines := readTrend(t, path)
+		if lines[1].Painted != 55 {
+			t.Errorf("%s: folder 5 has painted %d, want the rerun's 55", existing, lines[1].Painted)
+		}
+		if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+			t.Errorf("%s: the temp file was left behind", existing)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+func init() {
+	registerOperation(OperationSpec{
+		Key:    "i",
+		Params: []string{"n", "filter"},
+		Usage:  "mosaic, every tile shrunk to n x n pixels (n=default 4, up to 64 as long as the mosaic stays under 2^30 pixels, so 16 for the whole world; takes n*n*3 bytes per tile in memory so 16 is about 3GB; filter=mode or average, default mode)",
+		New:    newThumbnailOperation,
+	})
+}
+
+// Biggest mosaic allowed, which is also 3 bytes a pixel of grid. 16 for the whole world
+const maxMosaicPixels = 1 << 30
+
+// The record is n*n RGB blocks, row by row. Blocks with nothing painted stay black
+type thumbnailOperation struct {
+	pureOperation
+	n      int
+	filter string
+}
+
+func newThumbnailOperation(o Options) (Operation, error) {
+	n, err := o.Int("n", 4)
+	if err != nil {
+		return nil, err
+	}
+	if n < 1 || n > 64 {
+		return nil, fmt.Errorf("n (%d) has to be between 1 and 64", n)
+	}
+
+	filter := o.String("filter", "mode")
+	if filter != "mode" && filter != "average" {
+		return nil, fmt.Errorf("unknown filter %q", filter)
+	}
+
+	return thumbnailOperation{n: n, filter: filter}, nil
+}
+
+func (op thumbnailOperation) Name() string {
+	return fmt.Sprintf("mosaic%d-%s", op.n, op.filter)
+}
+
+func (op thumbnailOperation) CheckRegion(region Region) error {
+	if pixels := region.tiles() * op.n * op.n; pixels > maxMosaicPixels {
+		return fmt.Errorf("a %dx%d tile mosaic at n=%d is %d pixels, more than %d. Use a smaller n or region", region.Width, region.Height, op.n, pixels, maxMosaicPixels)
+	}
+	return nil
+}
+
+func (op thumbnailOperation) RecordSize() int {
+	return op.n * op.n * 3
+}
+
+func (op thumbnailOperation) Process(tile *Tile, rec []byte) error {
+	counts := make(map[uint32]int, 64)
+
+	// 1000 doesn't split evenly into most n, so blocks are a pixel bigger or smaller here and there
+	for by := range op.n {
+		y0, y1 := by*tile.Height/op.n, (by+1)*tile.Height/op.n
+		for bx := range op.n {
+			x0, x1 := bx*tile.Width/op.n, (bx+1)*tile.Width/op.n
+
+			var rgb RGB
+			switch {
+			case op.filter == "mode" && tile.paletted():
+				clear(counts)
+				rgb = blockModePaletted(tile, x0, y0, x1, y1, counts)
+			case op.filter == "mode":
+				clear(counts)
+				rgb = blockMode(tile, x0, y0, x1, y1, counts)
+			case tile.paletted():
+				rgb = blockAveragePaletted(tile, x0, y0, x1, y1)
+			default:
+				rgb = blockAverage(tile, x0, y0, x1, y1)
+			}
+			rgb.put(rec[(by*op.n+bx)*3:])
+		}
+	}
+	return nil
+}
+
+// Most common painted colour in the block, ties going by palette order like everywhere else
+func blockMode(tile *Tile, x0, y0, x1, y1 int, counts map[uint32]int) RGB {
+	for y := y0; y < y1; y++ {
+		off := (y*tile.Width + x0) * 4
+		for x := x0; x < x1; x++ {
+			if tile.Pix[off+3] > 0 {
+				counts[uint32(tile.Pix[off])<<16|uint32(tile.Pix[off+1])<<8|uint32(tile.Pix[off+2])]++
+			}
+			off += 4
+		}
+	}
+
+	best, ok := mostCommon(counts)
+	if !ok {
+		return RGB{0, 0, 0}
+	}
+	return unpackRGB(best.packed)
+}
+
+func blockModePaletted(tile *Tile, x0, y0, x1, y1 int, counts map[uint32]int) RGB {
+	var indices [256]int
+	for y := y0; y < y1; y++ {
+		for _, i := range tile.Index[y*tile.Width+x0 : y*tile.Width+x1] {
+			indices[i]++
+		}
+	}
+
+	for i, n := range indices {
+		if n > 0 && tile.Palette[i].painted {
+			counts[tile.Palette[i].packed] += n
+		}
+	}
+
+	best, ok := mostCommon(counts)
+	if !ok {
+		return RGB{0, 0, 0}
+	}
+	return unpackRGB(best.packed)
+}
+
+func blockAverage(tile *Tile, x0, y0, x1, y1 int) RGB {
+	var r, g, b, count uint64
+	for y := y0; y < y1; y++ {
+		off := (y*tile.Width + x0) * 4
+		for x := x0; x < x1; x++ {
+			if tile.Pix[off+3] > 0 {
+				r += uint64(tile.Pix[off])
+				g += uint64(tile.Pix[off+1])
+				b += uint64(tile.Pix[off+2])
+				count++
+			}
+			off += 4
+		}
+	}
+
+	if count == 0 {
+		return RGB{0, 0, 0}
+	}
+	return RGB{R: uint8(r / count), G: uint8(g / count), B: uint8(b / count)}
+}
+
+func blockAveragePaletted(tile *Tile, x0, y0, x1, y1 int) RGB {
+	var r, g, b, count uint64
+	for y := y0; y < y1; y++ {
+		for _, i := range tile.Index[y*tile.Width+x0 : y*tile.Width+x1] {
+			e := tile.Palette[i]
+			if e.painted {
+				r += uint64(e.packed >> 16 & 0xff)
+				g += uint64(e.packed >> 8 & 0xff)
+				b += uint64(e.packed & 0xff)
+				count++
+			}
+		}
+	}
+
+	if count == 0 {
+		return RGB{0, 0, 0}
+	}
+	return RGB{R: uint8(r / count), G: uint8(g / count), B: uint8(b / count)}
+}
+
+func (op thumbnailOperation) Encode(out *Output, grid *Grid) error {
+	return out.SavePNG(op.Name(), &mosaicImage{grid: grid, n: op.n, out: out})
+}
+
+// The mosaic is (width*n) x (height*n), far too big to build as an image.RGBA at bigger n,
+// so pixels come straight out of the grid as the encoder asks for them
+type mosaicImage struct {
+	grid *Grid
+	n    int
+	out  *Output
+}
+
+func (m *mosaicImage) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+func (m *mosaicImage) Bounds() image.Rectangle {
+	return image.Rect(0, 0, m.grid.Width*m.n, m.grid.Height*m.n)
+}
+
+func (m *mosaicImage) At(x, y int) color.Color {
+	tx, ty := x/m.n, y/m.n
+	if m.out.marked(tx, ty) {
+		return color.RGBA{m.out.Marker.R, m.out.Marker.G, m.out.Marker.B, 255}
+	}
+
+	rec := m.grid.At(tx, ty)
+	off := ((y%m.n)*m.n + x%m.n) * 3
+	return color.RGBA{rec[off], rec[off+1], rec[off+2], 255}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestThumbnailBlocks(t *testing.T) {
+	// n=2 on a 4x4 tile, so every 2x2 quarter is a block:
+	//   top left all red, top right 3 blue and a red, bottom left empty,
+	//   bottom right 2 red and 2 white, which ties and goes to white by palette order
+	img := testPaletted(4, 4, []RGB{red, blue, white}, []uint8{
+		1, 1, 2, 2,
+		1, 1, 2, 1,
+		0, 0, 1, 3,
+		0, 0, 3, 1,
+	})
+	avg := func(cs ...RGB) RGB {
+		var r, g, b int
+		for _, c := range cs {
+			r, g, b = r+int(c.R), g+int(c.G), b+int(c.B)
+		}
+		return RGB{uint8(r / len(cs)), uint8(g / len(cs)), uint8(b / len(cs))}
+	}
+
+	tests := []struct {
+		filter string
+		want   [4]RGB
+	}{
+		{"mode", [4]RGB{red, blue, {}, white}},
+		{"average", [4]RGB{red, avg(blue, blue, blue, red), {}, avg(red, red, white, white)}},
+	}
+	for _, tt := range tests {
+		op, err := parseOperation("i:n=2:filter=" + tt.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, kind := range tileKinds {
+			rec := make([]byte, op.RecordSize())
+			if err := op.Process(testTile(t, img, kind.rgba), rec); err != nil {
+				t.Fatal(err)
+			}
+			for i, want := range tt.want {
+				if got := rgbFromRecord(rec[i*3:]); got != want {
+					t.Errorf("%s %s: block %d = %s, want %s", tt.filter, kind.name, i, got.hex(), want.hex())
+				}
+			}
+		}
+	}
+}
+
+func TestThumbnailCheckRegion(t *testing.T) {
+	tests := []struct {
+		n      int
+		region Region
+		ok     bool
+	}{
+		{16, worldRegion(), true},
+		{17, worldRegion(), false},
+		{64, Region{Width: 100, Height: 100}, true},
+		{64, Region{Width: 600, Height: 500}, false},
+	}
+	for _, tt := range tests {
+		op, err := newThumbnailOperation(Options{Params: map[string]string{"n": strconv.Itoa(tt.n)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = checkRegion([]Operation{op}, tt.region)
+		if (err == nil) != tt.ok {
+			t.Errorf("n=%d %dx%d: err = %v, want ok = %v", tt.n, tt.region.Width, tt.region.Height, err, tt.ok)
+		}
+		if err != nil && !strings.HasPrefix(err.Error(), op.Name()+": ") {
+			t.Errorf("error %q doesn't say which operation", err)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Shares go through the colour ramp as basis points, so 10000 is the whole tile
+const shareScale = 10_000
+
+func init() {
+	registerOperation(OperationSpec{
+		Key:    "k",
+		Params: append([]string{"n", "maps"}, rampParams...),
+		Usage:  "top n colours and their shares (n=how many, default 3, maps=what to render joined by +, e.g. colour2+share1, default share1, shares also take the count ramp params)",
+		New:    newTopOperation,
+	})
+}
+
+type topMap struct {
+	show string // "colour" or "share"
+	rank int    // 1 = most common
+}
+
+// The record is the painted count, then n slots of colour + pixel count, most common first.
+// Slots past the number of colours in the tile are left zeroed
+type topOperation struct {
+	pureOperation
+	n    int
+	maps []topMap
+	ramp *colourRamp
+}
+
+func newTopOperation(o Options) (Operation, error) {
+	n, err := o.Int("n", 3)
+	if err != nil {
+		return nil, err
+	}
+	if n < 1 || n > len(wplacePalette) {
+		return nil, fmt.Errorf("n (%d) has to be between 1 and %d", n, len(wplacePalette))
+	}
+
+	op := &topOperation{n: n}
+
+	for _, m := range strings.Split(o.String("maps", "share1"), "+") {
+		if m == "" {
+			continue
+		}
+		show := strings.TrimRight(m, "0123456789")
+		if show != "colour" && show != "share" {
+			return nil, fmt.Errorf("map %q should be colourN or shareN", m)
+		}
+		rank, err := strconv.Atoi(m[len(show):])
+		if err != nil || rank < 1 || rank > n {
+			return nil, fmt.Errorf("map %q needs a rank between 1 and %d", m, n)
+		}
+		op.maps = append(op.maps, topMap{show: show, rank: rank})
+	}
+
+	op.ramp, err = newColourRamp(o, shareScale, "linear", "viridis")
+	if err != nil {
+		return nil, err
+	}
+
+	return op, nil
+}
+
+func (op *topOperation) Name() string {
+	return fmt.Sprintf("top%d", op.n)
+}
+
+func (op *topOperation) RecordSize() int {
+	return 4 + op.n*7
+}
+
+func (op *topOperation) Process(tile *Tile, rec []byte) error {
+	counts := make(map[uint32]int, 64)
+	var painted uint32
+
+	if tile.paletted() {
+		_, _, _, n := paletteColourCounts(tile, counts)
+		painted = uint32(n)
+	} else {
+		for i := 0; i < tile.Width*tile.Height*4; i += 4 {
+			if tile.Pix[i+3] == 0 {
+				continue
+			}
+			counts[uint32(tile.Pix[i])<<16|uint32(tile.Pix[i+1])<<8|uint32(tile.Pix[i+2])]++
+			painted++
+		}
+	}
+
+	binary.LittleEndian.PutUint32(rec[0:4], painted)
+	for i, c := range rankColours(counts)[:min(op.n, len(counts))] {
+		slot := rec[4+i*7 : 4+(i+1)*7]
+		unpackRGB(c.packed).put(slot[0:3])
+		binary.LittleEndian.PutUint32(slot[3:7], uint32(c.count))
+	}
+	return nil
+}
+
+// rank is 1-based, ok is false if the tile doesn't have that many colours
+func (op *topOperation) slot(rec []byte, rank int) (rgb RGB, count uint32, ok bool) {
+	slot := rec[4+(rank-1)*7 : 4+rank*7]
+	count = binary.LittleEndian.Uint32(slot[3:7])
+	return rgbFromRecord(slot[0:3]), count, count > 0
+}
+
+func (op *topOperation) share(rec []byte, rank int) uint32 {
+	painted := binary.LittleEndian.Uint32(rec[0:4])
+	_, count, ok := op.slot(rec, rank)
+	if !ok || painted == 0 {
+		return 0
+	}
+	return uint32(uint64(count) * shareScale / uint64(painted))
+}
+
+func (op *topOperation) Encode(out *Output, grid *Grid) error {
+	if err := op.saveCSV(out.Path(op.Name(), "csv"), grid); err != nil {
+		return err
+	}
+
+	var shareMaps []tileMap
+	for _, m := range op.maps {
+		name := fmt.Sprintf("%s-%s%d", op.Name(), m.show, m.rank)
+
+		if m.show == "colour" {
+			err := out.SaveRGB(name, grid.Width, grid.Height, func(x, y int) RGB {
+				rgb, _, _ := op.slot(grid.At(x, y), m.rank)
+				return rgb
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		shareMaps = append(shareMaps, tileMap{
+			name + op.ramp.suffix(),
+			op.ramp,
+			func(rec []byte) uint32 { return op.share(rec, m.rank) },
+			fmt.Sprintf("share of colour #%d per tile", m.rank),
+			func(v float64) string { return fmt.Sprintf("%.1f%%", v*100/shareScale) },
+		})
+	}
+
+	return saveTileMaps(out, grid, shareMaps)
+}
+
+// One row per painted tile: x,y,painted then colourN,shareN pairs, blank where the tile runs out of colours
+func (op *topOperation) saveCSV(outputPath string, grid *Grid) error {
+	header := "x,y,painted"
+	for i := 1; i <= op.n; i++ {
+		header += fmt.Sprintf(",colour%d,share%d", i, i)
+	}
+
+	var line []byte
+	return saveTileCSV(outputPath, header, grid, func(x, y int, rec []byte) string {
+		painted := binary.LittleEndian.Uint32(rec[0:4])
+		if painted == 0 {
+			return ""
+		}
+
+		line = strconv.AppendUint(line[:0], uint64(painted), 10)
+		for rank := 1; rank <= op.n; rank++ {
+			rgb, count, ok := op.slot(rec, rank)
+			line = append(line, ',')
+			if ok {
+				line = append(line, rgb.hex()...)
+			}
+			line = append(line, ',')
+			if ok {
+				line = strconv.AppendFloat(line, float64(count)/float64(painted), 'f', 4, 64)
+			}
+		}
+		return string(line)
+	})
+}
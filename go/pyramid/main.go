@@ -0,0 +1,301 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tilearchive"
+)
+
+const (
+	tileSize = 1000
+
+	// wplace is 2048x2048 tiles, which is zoom 11. Every level above halves it until it's one tile
+	maxZoom = 11
+)
+
+var wplacePath string = "C:/Users/jazza/Downloads/wplace"
+
+type Coord = tilearchive.Coord
+
+func main() {
+	folderNumber := 1
+	singleFolder := false
+	numWorkers := 16
+	outPath := ""
+
+	flag.IntVar(&folderNumber, "f", folderNumber, "The folder number to build the pyramid for")
+	flag.StringVar(&wplacePath, "p", wplacePath, "The path to the wplace folder, namely the folder containing the tiles-x folder")
+	flag.BoolVar(&singleFolder, "s", singleFolder, "Whether the archive is tiles-x.7z/tiles-x or just tiles-x.7z")
+	flag.IntVar(&numWorkers, "w", numWorkers, "The number of workers to use")
+	flag.StringVar(&outPath, "o", outPath, "Where to write <z>/<x>/<y>.png, defaults to <wplace>/pyramid/<folder>")
+	flag.Parse()
+
+	tilesFolderPath := tilearchive.FolderPath(wplacePath, folderNumber, singleFolder)
+	if _, err := os.Stat(tilesFolderPath); err != nil {
+		fmt.Printf("Folder \"%s\" does not exist!\n", tilesFolderPath)
+		os.Exit(1)
+	}
+
+	if outPath == "" {
+		outPath = fmt.Sprintf("%s/pyramid/%d", wplacePath, folderNumber)
+	}
+
+	startTime := time.Now()
+
+	// Anything already in the output is left alone, so a run that got cut off just carries on.
+	// Tiles are only ever renamed into place once they're complete, and a level is only started once
+	// the one below it is finished, so whatever is there can be trusted
+	tiles := linkLevel(tilesFolderPath, outPath, numWorkers)
+	for z := maxZoom - 1; z >= 0; z-- {
+		tiles = buildLevel(outPath, z, tiles, numWorkers)
+	}
+
+	fmt.Printf("Done! Took: %v\n", time.Since(startTime).Round(time.Millisecond))
+	fmt.Printf("Leaflet: L.tileLayer('%s/{z}/{x}/{y}.png', {tileSize: %d, minZoom: 0, maxZoom: %d, noWrap: true})\n", outPath, tileSize, maxZoom)
+}
+
+func runWorkers[T any](items []T, numWorkers int, fn func(T)) {
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+
+	var wg sync.WaitGroup
+	jobs := make(chan T)
+
+	for range numWorkers {
+		wg.Go(func() {
+			for it := range jobs {
+				fn(it)
+			}
+		})
+	}
+
+	for _, it := range items {
+		jobs <- it
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+func tilePath(outPath string, z int, c Coord) string {
+	return fmt.Sprintf("%s/%d/%d/%d.png", outPath, z, c.X, c.Y)
+}
+
+// The deepest level is just the tiles themselves, hard linked if possible so it costs no space
+func linkLevel(tilesFolderPath, outPath string, numWorkers int) []Coord {
+	tiles, err := tilearchive.ListFolder(tilesFolderPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Zoom %d: %d tiles from %s\n", maxZoom, len(tiles), tilesFolderPath)
+
+	var skipped atomic.Int64
+	runWorkers(tiles, numWorkers, func(c Coord) {
+		dst := tilePath(outPath, maxZoom, c)
+		if exists(dst) {
+			skipped.Add(1)
+			return
+		}
+
+		src := fmt.Sprintf("%s/%d/%d.png", tilesFolderPath, c.X, c.Y)
+		if err := linkOrCopy(src, dst); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	})
+
+	if n := skipped.Load(); n > 0 {
+		fmt.Printf("%d were already there\n", n)
+	}
+	return tiles
+}
+
+func linkOrCopy(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmpPath := dst + ".tmpwrite"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, dst)
+}
+
+// Makes level z out of level z+1. children is every tile that exists one level down, a tile here
+// only exists if at least one of its four children does
+func buildLevel(outPath string, z int, children []Coord, numWorkers int) []Coord {
+	seen := make(map[Coord]bool, len(children)/2)
+	var parents []Coord
+	for _, c := range children {
+		p := Coord{X: c.X / 2, Y: c.Y / 2}
+		if !seen[p] {
+			seen[p] = true
+			parents = append(parents, p)
+		}
+	}
+
+	fmt.Printf("Zoom %d: %d tiles\n", z, len(parents))
+	levelStart := time.Now()
+
+	var skipped atomic.Int64
+	runWorkers(parents, numWorkers, func(p Coord) {
+		dst := tilePath(outPath, z, p)
+		if exists(dst) {
+			skipped.Add(1)
+			return
+		}
+
+		img, err := mergeChildren(outPath, z+1, p)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := writePNGAtomic(dst+".tmpwrite", dst, img); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	})
+
+	if n := skipped.Load(); n > 0 {
+		fmt.Printf("%d were already there\n", n)
+	}
+	fmt.Printf("Zoom %d took: %v\n", z, time.Since(levelStart).Round(time.Millisecond))
+	return parents
+}
+
+// The four children of p, each shrunk to a quarter and put in its corner. Missing ones stay transparent
+func mergeChildren(outPath string, childZoom int, p Coord) (*image.RGBA, error) {
+	dst := image.NewRGBA(image.Rect(0, 0, tileSize, tileSize))
+	half := tileSize / 2
+
+	for dy := range 2 {
+		for dx := range 2 {
+			c := Coord{X: p.X*2 + dx, Y: p.Y*2 + dy}
+			child, err := imageFromFile(tilePath(outPath, childZoom, c))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("zoom %d tile %d/%d: %w", childZoom, c.X, c.Y, err)
+			}
+			downsample(dst, child, dx*half, dy*half)
+		}
+	}
+
+	return dst, nil
+}
+
+// Every 2x2 block becomes one pixel. Tiles are pixel art with alpha only ever 0 or 255, so rather
+// than going see-through the pixel is painted if any of the block is, in the average of those. That
+// way single pixels and 1px lines are still there zoomed out, just thicker than they really are
+func downsample(dst, src *image.RGBA, offX, offY int) {
+	bounds := src.Bounds()
+	w, h := bounds.Dx()/2, bounds.Dy()/2
+
+	for y := range h {
+		for x := range w {
+			var r, g, b, painted int
+			for _, o := range [4][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				i := (y*2+o[1])*src.Stride + (x*2+o[0])*4
+				if src.Pix[i+3] == 0 {
+					continue
+				}
+				r += int(src.Pix[i])
+				g += int(src.Pix[i+1])
+				b += int(src.Pix[i+2])
+				painted++
+			}
+			if painted == 0 {
+				continue
+			}
+
+			j := (offY+y)*dst.Stride + (offX+x)*4
+			dst.Pix[j] = uint8(r / painted)
+			dst.Pix[j+1] = uint8(g / painted)
+			dst.Pix[j+2] = uint8(b / painted)
+			dst.Pix[j+3] = 255
+		}
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func imageFromFile(filepath string) (*image.RGBA, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, err := png.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba, nil
+	}
+
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba, nil
+}
+
+func writePNGAtomic(tmpPath, finalPath string, img image.Image) error {
+	if err := os.MkdirAll(filepath.Dir(finalPath), 0o755); err != nil {
+		return fmt.Errorf("mkdir %s: %w", filepath.Dir(finalPath), err)
+	}
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", tmpPath, err)
+	}
+	if err := png.Encode(out, img); err != nil {
+		out.Close()
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("encode %s: %w", tmpPath, err)
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("close %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, finalPath); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("rename %s -> %s: %w", tmpPath, finalPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDownsample(t *testing.T) {
+	// Two 2x2 blocks: one all painted in two colours, one with a single painted pixel. Row 1 of the
+	// second block is left empty
+	src := image.NewRGBA(image.Rect(0, 0, 6, 2))
+	red, blue := color.RGBA{200, 0, 0, 255}, color.RGBA{0, 0, 100, 255}
+	src.SetRGBA(0, 0, red)
+	src.SetRGBA(1, 0, red)
+	src.SetRGBA(0, 1, blue)
+	src.SetRGBA(1, 1, blue)
+	src.SetRGBA(3, 1, red)
+
+	dst := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	downsample(dst, src, 4, 2)
+
+	want := map[image.Point]color.RGBA{
+		{4, 2}: {100, 0, 50, 255},
+		{5, 2}: red,
+	}
+	for y := range 8 {
+		for x := range 8 {
+			if got := dst.RGBAAt(x, y); got != want[image.Point{x, y}] {
+				t.Errorf("%d,%d = %v, want %v", x, y, got, want[image.Point{x, y}])
+			}
+		}
+	}
+}
+
+func writeTestPNG(t *testing.T, path string, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func solid(c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+// Whatever is already in the output was finished by an earlier run and is left alone
+func TestResumeSkipsWrittenTiles(t *testing.T) {
+	dir := t.TempDir()
+	tiles, out := filepath.Join(dir, "tiles"), filepath.Join(dir, "out")
+
+	children := []Coord{{X: 0, Y: 0}, {X: 1, Y: 0}, {X: 2, Y: 1}}
+	for _, c := range children {
+		writeTestPNG(t, fmt.Sprintf("%s/%d/%d.png", tiles, c.X, c.Y), solid(color.RGBA{10, 20, 30, 255}))
+	}
+
+	// A zoom 11 tile and a zoom 10 tile from a run that got cut off, both different to what this one
+	// would make
+	oldChild := writeTestPNG(t, tilePath(out, maxZoom, Coord{X: 1, Y: 0}), solid(color.RGBA{1, 1, 1, 255}))
+	oldParent := writeTestPNG(t, tilePath(out, maxZoom-1, Coord{X: 0, Y: 0}), solid(color.RGBA{2, 2, 2, 255}))
+
+	linked := linkLevel(tiles, out, 2)
+	slices.SortFunc(linked, func(a, b Coord) int { return a.X*10 + a.Y - b.X*10 - b.Y })
+	if !slices.Equal(linked, children) {
+		t.Errorf("linkLevel = %v, want %v", linked, children)
+	}
+	parents := buildLevel(out, maxZoom-1, linked, 2)
+	slices.SortFunc(parents, func(a, b Coord) int { return a.X - b.X })
+	if want := []Coord{{X: 0, Y: 0}, {X: 1, Y: 0}}; !slices.Equal(parents, want) {
+		t.Errorf("buildLevel = %v, want %v", parents, want)
+	}
+
+	for _, f := range []struct {
+		path string
+		want []byte
+	}{
+		{tilePath(out, maxZoom, Coord{X: 1, Y: 0}), oldChild},
+		{tilePath(out, maxZoom-1, Coord{X: 0, Y: 0}), oldParent},
+	} {
+		if got, err := os.ReadFile(f.path); err != nil || !bytes.Equal(got, f.want) {
+			t.Errorf("%s was rewritten", f.path)
+		}
+	}
+
+	// The one that wasn't there gets made, child 2,1 in the bottom left quarter of parent 1,0
+	img, err := imageFromFile(tilePath(out, maxZoom-1, Coord{X: 1, Y: 0}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.RGBAAt(0, tileSize/2); got != (color.RGBA{10, 20, 30, 255}) {
+		t.Errorf("new parent's bottom left = %v", got)
+	}
+	if got := img.RGBAAt(0, 0); got.A != 0 {
+		t.Errorf("new parent's top left = %v, want transparent", got)
+	}
+}
@@ -1,3 +1,7 @@
 module smart-crop
 
 go 1.25.1
+
+require components v0.0.0-00010101000000-000000000000
+
+replace components => ../components
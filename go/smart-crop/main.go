@@ -16,6 +16,8 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+
+	"components"
 )
 
 const (
@@ -37,11 +39,7 @@ const (
 
 var globalSeq uint64
 
-type component struct {
-	minX, minY int
-	maxX, maxY int
-	count      int
-}
+type component = components.Component
 
 var allowedExt = map[string]bool{
 	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".webp": true, ".bmp": true,
@@ -130,128 +128,6 @@ func loadAsNRGBA(path string) (*image.NRGBA, error) {
 	return dst, nil
 }
 
-func makeMaskNRGBA(img *image.NRGBA) (w, h int, mask []byte, solidCount int) {
-	b := img.Bounds()
-	w, h = b.Dx(), b.Dy()
-	mask = make([]byte, w*h)
-
-	p := 0
-	for y := 0; y < h; y++ {
-		row := y * img.Stride
-		for x := 0; x < w; x++ {
-			a := img.Pix[row+4*x+3]
-			solid := byte(0)
-			if int(a) >= ALPHA_THRESHOLD {
-				solid = 1
-				solidCount++
-			}
-			mask[p] = solid
-			p++
-		}
-	}
-	return
-}
-
-func dilateMask(w, h int, mask []byte, r int) []byte {
-	if r <= 0 {
-		cp := make([]byte, len(mask))
-		copy(cp, mask)
-		return cp
-	}
-	out := make([]byte, len(mask))
-	idx := func(x, y int) int { return y*w + x }
-	for y := range h {
-		for x := range w {
-			on := byte(0)
-			for dy := -r; dy <= r && on == 0; dy++ {
-				yy := y + dy
-				if yy < 0 || yy >= h {
-					continue
-				}
-				for dx := -r; dx <= r; dx++ {
-					xx := x + dx
-					if xx < 0 || xx >= w {
-						continue
-					}
-					if mask[idx(xx, yy)] != 0 {
-						on = 1
-						break
-					}
-				}
-			}
-			out[idx(x, y)] = on
-		}
-	}
-	return out
-}
-
-func findComponents8(w, h int, mask []byte) []component {
-	visited := make([]byte, len(mask))
-	idx := func(x, y int) int { return y*w + x }
-
-	qx := make([]int, w*h)
-	qy := make([]int, w*h)
-
-	var comps []component
-
-	for y := range h {
-		for x := range w {
-			p := idx(x, y)
-			if mask[p] == 0 || visited[p] != 0 {
-				continue
-			}
-			head, tail := 0, 0
-			qx[tail], qy[tail] = x, y
-			tail++
-			visited[p] = 1
-
-			minX, maxX := x, x
-			minY, maxY := y, y
-			count := 0
-
-			for head < tail {
-				cx, cy := qx[head], qy[head]
-				head++
-				count++
-
-				if cx < minX {
-					minX = cx
-				}
-				if cx > maxX {
-					maxX = cx
-				}
-				if cy < minY {
-					minY = cy
-				}
-				if cy > maxY {
-					maxY = cy
-				}
-
-				for dy := -1; dy <= 1; dy++ {
-					for dx := -1; dx <= 1; dx++ {
-						if dx == 0 && dy == 0 {
-							continue
-						}
-						nx, ny := cx+dx, cy+dy
-						if nx < 0 || ny < 0 || nx >= w || ny >= h {
-							continue
-						}
-						np := idx(nx, ny)
-						if mask[np] == 0 || visited[np] != 0 {
-							continue
-						}
-						visited[np] = 1
-						qx[tail], qy[tail] = nx, ny
-						tail++
-					}
-				}
-			}
-			comps = append(comps, component{minX, minY, maxX, maxY, count})
-		}
-	}
-	return comps
-}
-
 func mergeTouchingBoxes(boxes []component, gap int) []component {
 	if len(boxes) <= 1 {
 		cp := make([]component, len(boxes))
@@ -260,10 +136,10 @@ func mergeTouchingBoxes(boxes []component, gap int) []component {
 	}
 
 	expand := func(b component, g int) component {
-		return component{b.minX - g, b.minY - g, b.maxX + g, b.maxY + g, b.count}
+		return component{MinX: b.MinX - g, MinY: b.MinY - g, MaxX: b.MaxX + g, MaxY: b.MaxY + g, Count: b.Count}
 	}
 	intersects := func(a, b component) bool {
-		return !(a.maxX < b.minX || b.maxX < a.minX || a.maxY < b.minY || b.maxY < a.minY)
+		return !(a.MaxX < b.MinX || b.MaxX < a.MinX || a.MaxY < b.MinY || b.MaxY < a.MinY)
 	}
 
 	arr := make([]component, len(boxes))
@@ -288,19 +164,19 @@ func mergeTouchingBoxes(boxes []component, gap int) []component {
 				}
 				exOther := expand(arr[j], gap)
 				if intersects(exCur, exOther) {
-					if arr[j].minX < cur.minX {
-						cur.minX = arr[j].minX
+					if arr[j].MinX < cur.MinX {
+						cur.MinX = arr[j].MinX
 					}
-					if arr[j].minY < cur.minY {
-						cur.minY = arr[j].minY
+					if arr[j].MinY < cur.MinY {
+						cur.MinY = arr[j].MinY
 					}
-					if arr[j].maxX > cur.maxX {
-						cur.maxX = arr[j].maxX
+					if arr[j].MaxX > cur.MaxX {
+						cur.MaxX = arr[j].MaxX
 					}
-					if arr[j].maxY > cur.maxY {
-						cur.maxY = arr[j].maxY
+					if arr[j].MaxY > cur.MaxY {
+						cur.MaxY = arr[j].MaxY
 					}
-					cur.count += arr[j].count
+					cur.Count += arr[j].Count
 					used[j] = true
 					changed = true
 					exCur = expand(cur, gap)
@@ -317,10 +193,10 @@ func mergeTouchingBoxes(boxes []component, gap int) []component {
 }
 
 func tightenOnOriginal(mask []byte, w, h int, box component) (component, bool) {
-	minX := clampInt(box.minX, 0, w-1)
-	minY := clampInt(box.minY, 0, h-1)
-	maxX := clampInt(box.maxX, 0, w-1)
-	maxY := clampInt(box.maxY, 0, h-1)
+	minX := clampInt(box.MinX, 0, w-1)
+	minY := clampInt(box.MinY, 0, h-1)
+	maxX := clampInt(box.MaxX, 0, w-1)
+	maxY := clampInt(box.MaxY, 0, h-1)
 
 	x0, y0 := maxX, maxY
 	x1, y1 := minX, minY
@@ -349,7 +225,7 @@ func tightenOnOriginal(mask []byte, w, h int, box component) (component, bool) {
 	if cnt == 0 {
 		return component{}, false
 	}
-	return component{minX: x0, minY: y0, maxX: x1, maxY: y1, count: cnt}, true
+	return component{MinX: x0, MinY: y0, MaxX: x1, MaxY: y1, Count: cnt}, true
 }
 
 func cropAndPad(src *image.NRGBA, r image.Rectangle, pad int) *image.NRGBA {
@@ -492,7 +368,7 @@ func processImage(path string) error {
 	if err != nil {
 		return fmt.Errorf("load: %w", err)
 	}
-	W, H, mask, solidCount := makeMaskNRGBA(img)
+	W, H, mask, solidCount := components.MaskNRGBA(img, ALPHA_THRESHOLD)
 	base := basenameNoExt(path)
 	parent := filepath.Base(filepath.Dir(path))
 
@@ -501,8 +377,8 @@ func processImage(path string) error {
 		return nil
 	}
 
-	grown := dilateMask(W, H, mask, DILATE_RADIUS)
-	comps := findComponents8(W, H, grown)
+	grown := components.Dilate(W, H, mask, DILATE_RADIUS)
+	comps := components.Find8(W, H, grown)
 	if MERGE_GAP > 0 {
 		comps = mergeTouchingBoxes(comps, MERGE_GAP)
 	}
@@ -513,9 +389,9 @@ func processImage(path string) error {
 		if !ok {
 			continue
 		}
-		w := t.maxX - t.minX + 1
-		h := t.maxY - t.minY + 1
-		if t.count < MIN_GROUP_SOLID_PX {
+		w := t.MaxX - t.MinX + 1
+		h := t.MaxY - t.MinY + 1
+		if t.Count < MIN_GROUP_SOLID_PX {
 			continue
 		}
 		if w*h <= MIN_GROUP_AREA {
@@ -531,7 +407,7 @@ func processImage(path string) error {
 	for i := 0; i < len(tight)-1; i++ {
 		maxIdx := i
 		for j := i + 1; j < len(tight); j++ {
-			if tight[j].count > tight[maxIdx].count {
+			if tight[j].Count > tight[maxIdx].Count {
 				maxIdx = j
 			}
 		}
@@ -545,9 +421,9 @@ func processImage(path string) error {
 	}
 
 	for _, c := range tight {
-		x0, y0 := c.minX, c.minY
-		cw := c.maxX - c.minX + 1
-		ch := c.maxY - c.minY + 1
+		x0, y0 := c.MinX, c.MinY
+		cw := c.MaxX - c.MinX + 1
+		ch := c.MaxY - c.MinY + 1
 
 		cropRect := image.Rect(x0, y0, x0+cw, y0+ch)
 		cropped := cropAndPad(img, cropRect, PADDING_AT_1X)
@@ -0,0 +1,274 @@
+// Reads tiles straight out of the tiles-N.7z archives, no 7z binary or temp folder needed
+package tilearchive
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bodgit/sevenzip"
+)
+
+type Coord struct {
+	X, Y int
+}
+
+// One tiles-N/x/y.png member. Stream is the compressed stream it lives in,
+// reading members of the same stream in archive order is what keeps this fast
+type Entry struct {
+	Coord
+	Name   string
+	Size   int64
+	Stream int
+}
+
+type Archive struct {
+	Path string
+
+	rc      *sevenzip.ReadCloser
+	entries []Entry
+	files   map[Coord]*sevenzip.File
+}
+
+// <base>/tiles-N.7z
+func ArchivePath(base string, number int) string {
+	return filepath.Join(base, fmt.Sprintf("tiles-%d.7z", number))
+}
+
+// Where tiles-N ends up once it's extracted, <base>/tiles-N/tiles-N the way 7z x leaves it, or
+// <base>/tiles-N for one that was extracted without the extra folder
+func FolderPath(base string, number int, single bool) string {
+	if single {
+		return fmt.Sprintf("%s/tiles-%d", base, number)
+	}
+	return fmt.Sprintf("%s/tiles-%d/tiles-%d", base, number, number)
+}
+
+// Every x/y.png in an extracted folder, column by column
+func ListFolder(folder string) ([]Coord, error) {
+	columns, err := os.ReadDir(folder)
+	if err != nil {
+		return nil, err
+	}
+
+	var tiles []Coord
+	for _, column := range columns {
+		x, err := strconv.Atoi(column.Name())
+		if err != nil || !column.IsDir() {
+			continue
+		}
+
+		files, err := os.ReadDir(filepath.Join(folder, column.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			yStr, ok := strings.CutSuffix(f.Name(), ".png")
+			if !ok {
+				continue
+			}
+			if y, err := strconv.Atoi(yStr); err == nil {
+				tiles = append(tiles, Coord{X: x, Y: y})
+			}
+		}
+	}
+	return tiles, nil
+}
+
+func Open(archivePath string) (*Archive, error) {
+	rc, err := sevenzip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Archive{Path: archivePath, rc: rc, files: make(map[Coord]*sevenzip.File, len(rc.File))}
+
+	for _, f := range rc.File {
+		c, ok := parseTileName(f.Name)
+		if !ok || f.FileInfo().IsDir() {
+			continue
+		}
+		a.entries = append(a.entries, Entry{Coord: c, Name: f.Name, Size: int64(f.UncompressedSize), Stream: f.Stream})
+		a.files[c] = f
+	}
+
+	return a, nil
+}
+
+// Anything ending in <x>/<y>.png, so tiles-N/x/y.png and tiles-N/tiles-N/x/y.png both work
+func parseTileName(name string) (Coord, bool) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	dir, file := path.Split(name)
+	yStr, ok := strings.CutSuffix(file, ".png")
+	if !ok {
+		return Coord{}, false
+	}
+	xStr := path.Base(strings.TrimSuffix(dir, "/"))
+
+	x, errX := strconv.Atoi(xStr)
+	y, errY := strconv.Atoi(yStr)
+	if errX != nil || errY != nil {
+		return Coord{}, false
+	}
+	return Coord{X: x, Y: y}, true
+}
+
+func (a *Archive) Close() error {
+	return a.rc.Close()
+}
+
+// Every tile in archive order
+func (a *Archive) Entries() []Entry {
+	return a.entries
+}
+
+func (a *Archive) Has(x, y int) bool {
+	_, ok := a.files[Coord{x, y}]
+	return ok
+}
+
+// Hands every tile to fn, in archive order within each stream. Up to parallel streams are read
+// at once, so fn has to be safe to call from several goroutines. Stops at the first error
+func (a *Archive) Walk(parallel int, fn func(e Entry, data []byte) error) error {
+	return a.walk(a.entries, parallel, failOnRead(fn))
+}
+
+// Walk, but only for the given tiles. Still goes in archive order, so it's much cheaper than
+// opening each one on its own. Tiles that aren't in the archive are skipped, check Has first
+func (a *Archive) WalkTiles(coords []Coord, parallel int, fn func(e Entry, data []byte) error) error {
+	return a.walk(a.selectEntries(coords), parallel, failOnRead(fn))
+}
+
+// WalkTiles, but a tile that can't be read out of the archive is handed to fn with its error instead
+// of stopping the walk, so one broken tile doesn't take the rest of the folder with it
+func (a *Archive) ScanTiles(coords []Coord, parallel int, fn func(e Entry, data []byte, err error) error) error {
+	return a.walk(a.selectEntries(coords), parallel, fn)
+}
+
+func (a *Archive) selectEntries(coords []Coord) []Entry {
+	want := make(map[Coord]bool, len(coords))
+	for _, c := range coords {
+		want[c] = true
+	}
+
+	entries := make([]Entry, 0, len(coords))
+	for _, e := range a.entries {
+		if want[e.Coord] {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+func failOnRead(fn func(e Entry, data []byte) error) func(e Entry, data []byte, err error) error {
+	return func(e Entry, data []byte, err error) error {
+		if err != nil {
+			return err
+		}
+		return fn(e, data)
+	}
+}
+
+func (a *Archive) walk(entries []Entry, parallel int, fn func(e Entry, data []byte, err error) error) error {
+	byStream := make(map[int][]Entry)
+	for _, e := range entries {
+		byStream[e.Stream] = append(byStream[e.Stream], e)
+	}
+
+	streams := make([]int, 0, len(byStream))
+	for s := range byStream {
+		streams = append(streams, s)
+	}
+	slices.Sort(streams)
+
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+
+	jobs := make(chan []Entry)
+	for range parallel {
+		wg.Go(func() {
+			for entries := range jobs {
+				for _, e := range entries {
+					if failed() {
+						break
+					}
+					data, err := a.readEntry(e)
+					if err := fn(e, data, err); err != nil {
+						mu.Lock()
+						if firstErr == nil {
+							firstErr = err
+						}
+						mu.Unlock()
+						break
+					}
+				}
+			}
+		})
+	}
+
+	for _, s := range streams {
+		jobs <- byStream[s]
+	}
+	close(jobs)
+	wg.Wait()
+
+	return firstErr
+}
+
+// Closing straight after reading is what lets the library reuse the stream for the next tile
+func (a *Archive) readEntry(e Entry) ([]byte, error) {
+	rc, err := a.files[e.Coord].Open()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s: %w", a.Path, e.Name, err)
+	}
+	defer rc.Close()
+
+	buf := bytes.NewBuffer(make([]byte, 0, e.Size))
+	if _, err := buf.ReadFrom(rc); err != nil {
+		return nil, fmt.Errorf("%s: %s: %w", a.Path, e.Name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Writes every tile to <dst>/<member name>, the same layout 7z x would give. Fails on a member that
+// would end up outside dst instead of writing it
+func (a *Archive) Extract(dst string, parallel int) error {
+	return a.Walk(parallel, func(e Entry, data []byte) error {
+		target, err := extractTarget(dst, e.Name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", a.Path, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0o644)
+	})
+}
+
+// Where a member gets extracted to. Names come from whoever made the archive, so one like
+// ../../x/y.png or /x/y.png still matches a tile but mustn't be written where it points
+func extractTarget(dst, name string) (string, error) {
+	local := filepath.FromSlash(strings.ReplaceAll(name, "\\", "/"))
+	if !filepath.IsLocal(local) {
+		return "", fmt.Errorf("%s: not a relative path inside the archive", name)
+	}
+	return filepath.Join(dst, local), nil
+}
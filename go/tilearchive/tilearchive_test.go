@@ -0,0 +1,69 @@
+package tilearchive
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestExtractTarget(t *testing.T) {
+	dst := filepath.Join("out", "dst")
+	tests := []struct {
+		name string
+		want string // "" = rejected
+	}{
+		{"tiles-3/1860/1281.png", filepath.Join(dst, "tiles-3", "1860", "1281.png")},
+		{"tiles-3\\1860\\1281.png", filepath.Join(dst, "tiles-3", "1860", "1281.png")},
+		{"tiles-3/../1860/1281.png", filepath.Join(dst, "1860", "1281.png")},
+		{"../1860/1281.png", ""},
+		{"tiles-3/../../1860/1281.png", ""},
+		{"..\\..\\1860\\1281.png", ""},
+		{"/etc/1860/1281.png", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got, err := extractTarget(dst, tt.name)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%q: extracted to %s, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%q = %s, %v, want %s", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+// Only <number>/<number>.png counts, anything else lying around the folder is skipped
+func TestListFolder(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"3/7.png", "3/8.png", "12/0.png", "3/notes.txt", "3/x.png", "thumbs/1.png", "5.png"} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := ListFolder(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(got, func(a, b Coord) int {
+		if a.X != b.X {
+			return a.X - b.X
+		}
+		return a.Y - b.Y
+	})
+	if want := []Coord{{3, 7}, {3, 8}, {12, 0}}; !slices.Equal(got, want) {
+		t.Errorf("ListFolder = %v, want %v", got, want)
+	}
+
+	if _, err := ListFolder(filepath.Join(dir, "missing")); err == nil {
+		t.Error("no error for a missing folder")
+	}
+}
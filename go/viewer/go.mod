@@ -0,0 +1,3 @@
+module viewer
+
+go 1.25.1
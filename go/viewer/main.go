@@ -0,0 +1,156 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// wplace's own zoom, 2048x2048 tiles. Anything below comes from a pyramid if one's been built
+const maxZoom = 11
+
+var wplacePath string = "C:/Users/jazza/Downloads/wplace"
+var singleFolder bool
+
+//go:embed index.html
+var indexPage []byte
+
+// A tiles-N folder, see listSnapshots
+var snapshotName = regexp.MustCompile(`^tiles-(\d+)$`)
+
+type Snapshot struct {
+	Number  int       `json:"number"`
+	Time    time.Time `json:"time"`
+	Pyramid bool      `json:"pyramid"`
+}
+
+func main() {
+	addr := "localhost:8080"
+
+	flag.StringVar(&wplacePath, "p", wplacePath, "The path to the wplace folder, namely the folder containing the tiles-x folders")
+	flag.BoolVar(&singleFolder, "s", singleFolder, "Whether the archive is tiles-x.7z/tiles-x or just tiles-x.7z")
+	flag.StringVar(&addr, "a", addr, "The address to listen on")
+	flag.Parse()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", serveIndex)
+	mux.HandleFunc("GET /snapshots", serveSnapshots)
+	mux.HandleFunc("GET /tiles/{n}/{z}/{x}/{y}", serveTile)
+
+	snapshots := listSnapshots()
+	fmt.Printf("Found %d extracted snapshots in %s\n", len(snapshots), wplacePath)
+	fmt.Printf("Listening on http://%s\n", addr)
+
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func getTilesFolderPath(wplaceOrTemp string, folderNumber int, singleFolder bool) (tilesFolderPath string) {
+	if singleFolder {
+		tilesFolderPath = fmt.Sprintf("%s/tiles-%d", wplaceOrTemp, folderNumber)
+	} else {
+		tilesFolderPath = fmt.Sprintf("%s/tiles-%d/tiles-%d", wplaceOrTemp, folderNumber, folderNumber)
+	}
+
+	return tilesFolderPath
+}
+
+// Where go/pyramid puts its output by default
+func getPyramidPath(folderNumber int) string {
+	return fmt.Sprintf("%s/pyramid/%d", wplacePath, folderNumber)
+}
+
+// Every tiles-N that's been extracted. Listed on every request so newly extracted ones show up
+// without a restart. The time is when the folder was written, which is close enough to when it was pulled
+func listSnapshots() []Snapshot {
+	entries, err := os.ReadDir(wplacePath)
+	if err != nil {
+		return nil
+	}
+
+	var snapshots []Snapshot
+	for _, e := range entries {
+		matches := snapshotName.FindStringSubmatch(e.Name())
+		if len(matches) != 2 || !e.IsDir() {
+			continue
+		}
+
+		n, err := strconv.Atoi(matches[1])
+		if err != nil {
+			continue
+		}
+
+		info, err := os.Stat(getTilesFolderPath(wplacePath, n, singleFolder))
+		if err != nil {
+			continue
+		}
+
+		_, err = os.Stat(getPyramidPath(n))
+		snapshots = append(snapshots, Snapshot{Number: n, Time: info.ModTime(), Pyramid: err == nil})
+	}
+
+	slices.SortFunc(snapshots, func(a, b Snapshot) int { return a.Number - b.Number })
+	return snapshots
+}
+
+func serveIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(indexPage)
+}
+
+func serveSnapshots(w http.ResponseWriter, r *http.Request) {
+	snapshots := listSnapshots()
+	if snapshots == nil {
+		snapshots = []Snapshot{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshots)
+}
+
+// /tiles/<n>/<z>/<x>/<y>.png. Zoom 11 is the tile straight out of tiles-N, anything below is from the
+// pyramid. Missing tiles are a plain 404, the map just leaves them empty
+func serveTile(w http.ResponseWriter, r *http.Request) {
+	var nums [4]int
+	for i, name := range []string{"n", "z", "x", "y"} {
+		v := strings.TrimSuffix(r.PathValue(name), ".png")
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("bad %s %q", name, v), http.StatusBadRequest)
+			return
+		}
+		nums[i] = n
+	}
+	n, z, x, y := nums[0], nums[1], nums[2], nums[3]
+
+	if z > maxZoom || x >= 1<<z || y >= 1<<z {
+		http.NotFound(w, r)
+		return
+	}
+
+	var tilePath string
+	if z == maxZoom {
+		tilePath = fmt.Sprintf("%s/%d/%d.png", getTilesFolderPath(wplacePath, n, singleFolder), x, y)
+	} else {
+		tilePath = fmt.Sprintf("%s/%d/%d/%d.png", getPyramidPath(n), z, x, y)
+	}
+
+	if _, err := os.Stat(tilePath); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// A snapshot never changes once it's pulled, so the browser can hang on to it
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeFile(w, r, tilePath)
+}